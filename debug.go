@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/animezb/newsroverd/extract"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+func init() {
+	http.HandleFunc("/debug/extract", explainExtraction)
+	http.Handle("/metrics", promhttp.Handler())
+}
+
+// explainExtraction serves extract.Explain as JSON for the group and subject
+// query parameters.
+func explainExtraction(w http.ResponseWriter, r *http.Request) {
+	group := r.FormValue("group")
+	subject := r.FormValue("subject")
+	if group == "" || subject == "" {
+		http.Error(w, "group and subject are required.", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(extract.Explain(group, subject))
+}
@@ -0,0 +1,133 @@
+package extract
+
+const (
+	e0 = `([-_](proof|sample|thumbs?))*(\.part\d*(\.rar)?|\.rar)?(\d{1,3}\.rev"|\.vol.+?"|\.[A-Za-z0-9]{2,4}"|")`
+	e1 = e0 + ` yEnc$`
+)
+
+// defaultRules are used when no rule file has been loaded. The order of the
+// sets matters, the rules of every set applying to a group are tried in the
+// order they are listed here.
+var defaultRules = RuleFile{
+	Sets: []RuleSet{
+		{
+			Name:   "hatsuyuki",
+			Groups: []string{"alt.binaries.multimedia.anime", "alt.binaries.multimedia.anime.highspeed"},
+			Rules: []Rule{
+				{
+					Example:  `High School DxD New 01 (480p|.avi|xvid|mp3) ~bY Hatsuyuki [01/18] - "[Hatsuyuki]_High_School_DxD_New_01_[848x480][76B2BB8C].avi.001" yEnc`,
+					Regex:    `.+? \((360|480|720|1080)p\|.+? ~bY .+? \[\d+\/\d+\] - "(.+?\[[A-F0-9]+\].+?)` + e1,
+					Index:    2,
+					Partless: true,
+				},
+			},
+		},
+		{
+			Name:   "anime",
+			Groups: []string{"alt.binaries.anime", "alt.binaries.multimedia.anime", "alt.binaries.multimedia.anime.highspeed"},
+			Rules: []Rule{
+				{
+					Example:  `([AST] One Piece Episode 301-350 [720p]) [007/340] - "One Piece episode 301-350.part006.rar" yEnc`,
+					Regex:    `^\((\[.+?\] .+?)\) \[\d+\/\d+\] - ".+?" yEnc$`,
+					Index:    1,
+					Partless: true,
+				},
+				{
+					Example:  `[REPOST][ New Doraemon 2013.05.03 Episode 328 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org ] [35/61] - "doraclub.org-doraemon-20130503-b8de1f8e.r32" yEnc`,
+					Regex:    `^\[.+?\]\[ (.+?) \] \[\d+\/\d+\] - ".+?" yEnc$`,
+					Index:    1,
+					Partless: true,
+				},
+				{
+					Example:  `[De.us] Suzumiya Haruhi no Shoushitsu (1920x1080 h.264 Dual-Audio FLAC 10-bit) [017CB24D] [000/357] - "[De.us] Suzumiya Haruhi no Shoushitsu (1920x1080 h.264 Dual-Audio FLAC 10-bit) [017CB24D].nzb" yEnc`,
+					Regex:    `^(\[.+?\] [.+?] \[[A-F0-9]+\]) \[\d+\/\d+\] - ".+?" yEnc$`,
+					Index:    1,
+					Partless: true,
+				},
+				{
+					Example:  `[eraser] Ghost in the Shell ARISE - border_1 Ghost Pain (BD 720p Hi444PP LC-AAC Stereo) - [01/65] - "[eraser] Ghost in the Shell ARISE - border_1 Ghost Pain (BD 720p Hi444PP LC-AAC Stereo) .md5" yEnc`,
+					Regex:    `^\[.+?\] (.+?) - \[\d+\/\d+\] - ".+?" yEnc$`,
+					Index:    1,
+					Partless: true,
+				},
+				{
+					Example:  `(01/27) - Maid.Sama.Jap.dubbed.german.english.subbed - "01 Misaki ist eine Maid!.divx" - 6,44 GB - yEnc`,
+					Regex:    `^\(\d+\/\d+\) - (.+?) - ".+?" - \d+[,.]\d+ [mMkKgG][bB] - yEnc$`,
+					Index:    1,
+					Partless: true,
+				},
+				{
+					Example:  `[ New Doraemon 2013.06.14 Episode 334 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org ] [01/60] - "doraclub.org-doraemon-20130614-fae28cec.nfo" yEnc`,
+					Regex:    `^\[ (.+?) \] \[\d+\/\d+\] - ".+?" yEnc$`,
+					Index:    1,
+					Partless: true,
+				},
+				{
+					Example:  `<TOWN> www.town.ag > sponsored by www.ssl-news.info > (1/3) "HolzWerken_40.par2" - 43,89 MB - yEnc`,
+					Regex:    `^<TOWN> www\.town\.ag > sponsored by www\.ssl-news\.info > \(\d+\/\d+\) "(.+?)` + e0 + ` - \d+[,.]\d+ [mMkKgG][bB] - yEnc$`,
+					Index:    1,
+					Partless: true,
+				},
+				{
+					Example:  `(1/9)<<<www.town.ag>>> sponsored by ssl-news.info<<<[HorribleSubs]_AIURA_-_01_[480p].mkv "[HorribleSubs]_AIURA_-_01_[480p].par2" yEnc`,
+					Regex:    `^\(\d+\/\d+\).+?www\.town\.ag.+?sponsored by (www\.)?ssl-news\.info<+?.+? "(.+?)` + e1,
+					Index:    2,
+					Partless: true,
+				},
+				{
+					Example:  `Overman King Gainer [Dual audio, EngSub] Exiled Destiny - [002/149] - "Overman King Gainer.part001.rar" yEnc`,
+					Regex:    `^(.+? \[Dual [aA]udio, EngSub\] .+?) - \[\d+\/\d+\] - ".+?" yEnc$`,
+					Index:    1,
+					Partless: true,
+				},
+				{
+					Example:  `[ TOWN ]-[ www.town.ag ]-[ partner of www.ssl-news.info ]-[ MOVIE ] [14/19] - "Night.Vision.2011.DVDRip.x264-IGUANA.part12.rar" - 660,80 MB yEnc`,
+					Regex:    `(?i)^\[ TOWN \][ _-]{0,3}\[ www\.town\.ag \][ _-]{0,3}\[ partner of www\.ssl-news\.info \][ _-]{0,3}\[ .* \] \[\d+\/\d+\][ _-]{0,3}("|#34;)(.+)((\.part\d+\.rar)|(\.vol\d+\+\d+\.par2))("|#34;)[ _-]{0,3}\d+[.,]\d+ [kKmMgG][bB][ _-]{0,3}yEnc$`,
+					Index:    2,
+					Partless: true,
+				},
+				{
+					Example:  `[ TOWN ]-[ www.town.ag ]-[ partner of www.ssl-news.info ]-[ MOVIE ] [01/84] - "The.Butterfly.Effect.2.2006.1080p.BluRay.x264-LCHD.par2" - 7,49 GB yEnc`,
+					Regex:    `(?i)^\[ TOWN \][ _-]{0,3}\[ www\.town\.ag \][ _-]{0,3}\[ partner of www\.ssl-news\.info \][ _-]{0,3}\[ .* \] \[\d+\/\d+\][ _-]{0,3}("|#34;)(.+)\.(par2|rar|nfo|nzb)("|#34;)[ _-]{0,3}\d+[.,]\d+ [kKmMgG][bB][ _-]{0,3}yEnc$`,
+					Index:    2,
+					Partless: true,
+				},
+				{
+					Regex:   `(?i)^.*?\.mkv.*?"(?P<name>.*?)\.mkv`,
+					Capture: "name",
+				},
+				{
+					Regex:   `(?i)^(?P<name>.*?\]) \[(?P<parts>\d{1,3}\/\d{1,3})`,
+					Capture: "name",
+				},
+				{
+					Regex:   `(?i)^.*?"(?P<name>.*?(\]|\)))\.`,
+					Capture: "name",
+				},
+			},
+		},
+	},
+}
+
+// fallbackSuffix strips the split archive, par2 and extension suffixes from a
+// filename so every file of a release yields the same name (nzedb style).
+const fallbackSuffix = `(?:\.part\d+\.rar|\.vol\d+[+-]\d+\.par2|\.par2|\.r\d{2,3}|\.\d{3}|\.[A-Za-z0-9]{2,4}(?:\.\d{3})?)?`
+
+// fallbackRules are tried after a newsgroup's own rules, see SetFallback.
+var fallbackRules = RuleSet{
+	Name:   "fallback",
+	Groups: []string{"*"},
+	Rules: []Rule{
+		{
+			Example: `Some.Show.S01E05.720p.HDTV.x264-GRP [02/45] - "Some.Show.S01E05.720p.HDTV.x264-GRP.part01.rar" yEnc`,
+			Regex:   `"(?P<name>[^"]+?)` + fallbackSuffix + `"`,
+			Capture: "name",
+		},
+		{
+			Example:  `Some.Show.S01E05.720p.HDTV.x264-GRP.vol03+04.par2 yEnc`,
+			Regex:    `^(?:\[\d+\/\d+\] - )?(?P<name>\S+?)` + fallbackSuffix + ` yEnc$`,
+			Capture:  "name",
+			Partless: true,
+		},
+	},
+}
@@ -4,153 +4,96 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
-type releaseExtract struct {
-	regex        *regexp.Regexp
-	subjectIndex int
-	usePartless  bool
-}
-
-var subjectMatchers map[string][]releaseExtract
+// subjectMatchers holds the active *matcherTable. It is swapped as a whole
+// when rules are reloaded so extraction never blocks on a reload.
+var subjectMatchers atomic.Value
 var partLessA *regexp.Regexp
 var partLessB *regexp.Regexp
 var yencMatch *regexp.Regexp
 var fileCountMatch *regexp.Regexp
+var segmentCountMatch *regexp.Regexp
 var filenameMatch *regexp.Regexp
 
 func init() {
-	subjectMatchers = make(map[string][]releaseExtract)
-	compileRegex()
+	rules, err := compileRuleFile(defaultRules)
+	if err != nil {
+		panic(err)
+	}
+	baseRules = rules
+	fallback, err = compileRuleFile(RuleFile{Sets: []RuleSet{fallbackRules}})
+	if err != nil {
+		panic(err)
+	}
+	installRules()
 	partLessA = regexp.MustCompile(`(\(\d+\/\d+\))?(\(\d+\/\d+\))?(\(\d+\/\d+\))?(\(\d+\/\d+\))?(\(\d+\/\d+\))?(\(\d+\/\d+\))?(\(\d+\/\d+\))?$`)
 	partLessB = regexp.MustCompile(`(?i)yEnc.*?$`)
 
 	// nzedb/Binaries.php
 	yencMatch = regexp.MustCompile(`(.+yEnc)(\.\s*|\s*by xMas\s*|_|\s*--\s*READ NFO!\s*|\s*| \[S\d+E\d+\]|\s*".+"\s*)\((\d+)\/(\d+)\)`)
-	fileCountMatch = regexp.MustCompile(`(\[|\(|\s)(\d{1,5})(\/|(\s|_)of(\s|_)|\-)(\d{1,5})(\]|\)|\s|$|:)`)
+	fileCountMatch = regexp.MustCompile(`(?i)(\[|\(|\s)(\d{1,5})(\/|(\s|_)of(\s|_))(\d{1,5})(\]|\)|\s|$|:)`)
+	segmentCountMatch = regexp.MustCompile(`(?i)[\(\[\s](\d{1,5})(?:\/|\s+of\s+)(\d{1,5})[\)\]]?\s*$`)
 
 	filenameMatch = regexp.MustCompile(`(?i)"(.+)"`)
 }
 
-func addReleaseExtract(group string, regex string, subjectIndex int, usePartless bool) {
-	r := releaseExtract{
-		regex:        regexp.MustCompile(regex),
-		subjectIndex: subjectIndex,
-		usePartless:  usePartless,
-	}
-	if _, ok := subjectMatchers[group]; ok {
-		subjectMatchers[group] = append(subjectMatchers[group], r)
-	} else {
-		subjectMatchers[group] = []releaseExtract{r}
-	}
-}
-
-func compileRegex() {
-	e0 := `([-_](proof|sample|thumbs?))*(\.part\d*(\.rar)?|\.rar)?(\d{1,3}\.rev"|\.vol.+?"|\.[A-Za-z0-9]{2,4}"|")`
-	e1 := e0 + ` yEnc$`
-	//([AST] One Piece Episode 301-350 [720p]) [007/340] - "One Piece episode 301-350.part006.rar" yEnc
-	addReleaseExtract("alt.binaries.anime", `^\((\[.+?\] .+?)\) \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[REPOST][ New Doraemon 2013.05.03 Episode 328 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org ] [35/61] - "doraclub.org-doraemon-20130503-b8de1f8e.r32" yEnc
-	addReleaseExtract("alt.binaries.anime", `^\[.+?\]\[ (.+?) \] \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[De.us] Suzumiya Haruhi no Shoushitsu (1920x1080 h.264 Dual-Audio FLAC 10-bit) [017CB24D] [000/357] - "[De.us] Suzumiya Haruhi no Shoushitsu (1920x1080 h.264 Dual-Audio FLAC 10-bit) [017CB24D].nzb" yEnc
-	addReleaseExtract("alt.binaries.anime", `^(\[.+?\] [.+?] \[[A-F0-9]+\]) \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[eraser] Ghost in the Shell ARISE - border_1 Ghost Pain (BD 720p Hi444PP LC-AAC Stereo) - [01/65] - "[eraser] Ghost in the Shell ARISE - border_1 Ghost Pain (BD 720p Hi444PP LC-AAC Stereo) .md5" yEnc
-	addReleaseExtract("alt.binaries.anime", `^\[.+?\] (.+?) - \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//(01/27) - Maid.Sama.Jap.dubbed.german.english.subbed - "01 Misaki ist eine Maid!.divx" - 6,44 GB - yEnc
-	addReleaseExtract("alt.binaries.anime", `^\(\d+\/\d+\) - (.+?) - ".+?" - \d+[,.]\d+ [mMkKgG][bB] - yEnc$`, 1, true)
-	//[ New Doraemon 2013.06.14 Episode 334 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org ] [01/60] - "doraclub.org-doraemon-20130614-fae28cec.nfo" yEnc
-	addReleaseExtract("alt.binaries.anime", `^\[ (.+?) \] \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//<TOWN> www.town.ag > sponsored by www.ssl-news.info > (1/3) "HolzWerken_40.par2" - 43,89 MB - yEnc
-	addReleaseExtract("alt.binaries.anime", `^<TOWN> www\.town\.ag > sponsored by www\.ssl-news\.info > \(\d+\/\d+\) "(.+?)`+e0+` - \d+[,.]\d+ [mMkKgG][bB] - yEnc$`, 1, true)
-	//(1/9)<<<www.town.ag>>> sponsored by ssl-news.info<<<[HorribleSubs]_AIURA_-_01_[480p].mkv "[HorribleSubs]_AIURA_-_01_[480p].par2" yEnc
-	addReleaseExtract("alt.binaries.anime", `^\(\d+\/\d+\).+?www\.town\.ag.+?sponsored by (www\.)?ssl-news\.info<+?.+? "(.+?)`+e1, 2, true)
-	//Overman King Gainer [Dual audio, EngSub] Exiled Destiny - [002/149] - "Overman King Gainer.part001.rar" yEnc
-	addReleaseExtract("alt.binaries.anime", `^(.+? \[Dual [aA]udio, EngSub\] .+?) - \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[ TOWN ]-[ www.town.ag ]-[ partner of www.ssl-news.info ]-[ MOVIE ] [14/19] - "Night.Vision.2011.DVDRip.x264-IGUANA.part12.rar" - 660,80 MB yEnc
-	addReleaseExtract("alt.binaries.anime", `(?i)^\[ TOWN \][ _-]{0,3}\[ www\.town\.ag \][ _-]{0,3}\[ partner of www\.ssl-news\.info \][ _-]{0,3}\[ .* \] \[\d+\/\d+\][ _-]{0,3}("|#34;)(.+)((\.part\d+\.rar)|(\.vol\d+\+\d+\.par2))("|#34;)[ _-]{0,3}\d+[.,]\d+ [kKmMgG][bB][ _-]{0,3}yEnc$`, 2, true)
-	//[ TOWN ]-[ www.town.ag ]-[ partner of www.ssl-news.info ]-[ MOVIE ] [01/84] - "The.Butterfly.Effect.2.2006.1080p.BluRay.x264-LCHD.par2" - 7,49 GB yEnc
-	addReleaseExtract("alt.binaries.anime", `(?i)^\[ TOWN \][ _-]{0,3}\[ www\.town\.ag \][ _-]{0,3}\[ partner of www\.ssl-news\.info \][ _-]{0,3}\[ .* \] \[\d+\/\d+\][ _-]{0,3}("|#34;)(.+)\.(par2|rar|nfo|nzb)("|#34;)[ _-]{0,3}\d+[.,]\d+ [kKmMgG][bB][ _-]{0,3}yEnc$`, 2, true)
-	addReleaseExtract("alt.binaries.anime", "(?i)^.*?\\.mkv.*?\"(?P<name>.*?)\\.mkv", 1, false)
-	addReleaseExtract("alt.binaries.anime", "(?i)^(?P<name>.*?\\]) \\[(?P<parts>\\d{1,3}\\/\\d{1,3})", 1, false)
-	addReleaseExtract("alt.binaries.anime", "(?i)^.*?\"(?P<name>.*?(\\]|\\)))\\.", 1, false)
-
-	//High School DxD New 01 (480p|.avi|xvid|mp3) ~bY Hatsuyuki [01/18] - "[Hatsuyuki]_High_School_DxD_New_01_[848x480][76B2BB8C].avi.001" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `.+? \((360|480|720|1080)p\|.+? ~bY .+? \[\d+\/\d+\] - "(.+?\[[A-F0-9]+\].+?)`+e1, 2, true)
-	//([AST] One Piece Episode 301-350 [720p]) [007/340] - "One Piece episode 301-350.part006.rar" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `^\((\[.+?\] .+?)\) \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[REPOST][ New Doraemon 2013.05.03 Episode 328 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org ] [35/61] - "doraclub.org-doraemon-20130503-b8de1f8e.r32" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `^\[.+?\]\[ (.+?) \] \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[De.us] Suzumiya Haruhi no Shoushitsu (1920x1080 h.264 Dual-Audio FLAC 10-bit) [017CB24D] [000/357] - "[De.us] Suzumiya Haruhi no Shoushitsu (1920x1080 h.264 Dual-Audio FLAC 10-bit) [017CB24D].nzb" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `^(\[.+?\] [.+?] \[[A-F0-9]+\]) \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[eraser] Ghost in the Shell ARISE - border_1 Ghost Pain (BD 720p Hi444PP LC-AAC Stereo) - [01/65] - "[eraser] Ghost in the Shell ARISE - border_1 Ghost Pain (BD 720p Hi444PP LC-AAC Stereo) .md5" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `^\[.+?\] (.+?) - \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//(01/27) - Maid.Sama.Jap.dubbed.german.english.subbed - "01 Misaki ist eine Maid!.divx" - 6,44 GB - yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `^\(\d+\/\d+\) - (.+?) - ".+?" - \d+[,.]\d+ [mMkKgG][bB] - yEnc$`, 1, true)
-	//[ New Doraemon 2013.06.14 Episode 334 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org ] [01/60] - "doraclub.org-doraemon-20130614-fae28cec.nfo" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `^\[ (.+?) \] \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//<TOWN> www.town.ag > sponsored by www.ssl-news.info > (1/3) "HolzWerken_40.par2" - 43,89 MB - yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `^<TOWN> www\.town\.ag > sponsored by www\.ssl-news\.info > \(\d+\/\d+\) "(.+?)`+e0+` - \d+[,.]\d+ [mMkKgG][bB] - yEnc$`, 1, true)
-	//(1/9)<<<www.town.ag>>> sponsored by ssl-news.info<<<[HorribleSubs]_AIURA_-_01_[480p].mkv "[HorribleSubs]_AIURA_-_01_[480p].par2" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `^\(\d+\/\d+\).+?www\.town\.ag.+?sponsored by (www\.)?ssl-news\.info<+?.+? "(.+?)`+e1, 2, true)
-	//Overman King Gainer [Dual audio, EngSub] Exiled Destiny - [002/149] - "Overman King Gainer.part001.rar" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `^(.+? \[Dual [aA]udio, EngSub\] .+?) - \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[ TOWN ]-[ www.town.ag ]-[ partner of www.ssl-news.info ]-[ MOVIE ] [14/19] - "Night.Vision.2011.DVDRip.x264-IGUANA.part12.rar" - 660,80 MB yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `(?i)^\[ TOWN \][ _-]{0,3}\[ www\.town\.ag \][ _-]{0,3}\[ partner of www\.ssl-news\.info \][ _-]{0,3}\[ .* \] \[\d+\/\d+\][ _-]{0,3}("|#34;)(.+)((\.part\d+\.rar)|(\.vol\d+\+\d+\.par2))("|#34;)[ _-]{0,3}\d+[.,]\d+ [kKmMgG][bB][ _-]{0,3}yEnc$`, 2, true)
-	//[ TOWN ]-[ www.town.ag ]-[ partner of www.ssl-news.info ]-[ MOVIE ] [01/84] - "The.Butterfly.Effect.2.2006.1080p.BluRay.x264-LCHD.par2" - 7,49 GB yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime", `(?i)^\[ TOWN \][ _-]{0,3}\[ www\.town\.ag \][ _-]{0,3}\[ partner of www\.ssl-news\.info \][ _-]{0,3}\[ .* \] \[\d+\/\d+\][ _-]{0,3}("|#34;)(.+)\.(par2|rar|nfo|nzb)("|#34;)[ _-]{0,3}\d+[.,]\d+ [kKmMgG][bB][ _-]{0,3}yEnc$`, 2, true)
-	addReleaseExtract("alt.binaries.multimedia.anime", "(?i)^.*?\\.mkv.*?\"(?P<name>.*?)\\.mkv", 1, false)
-	addReleaseExtract("alt.binaries.multimedia.anime", "(?i)^(?P<name>.*?\\]) \\[(?P<parts>\\d{1,3}\\/\\d{1,3})", 1, false)
-	addReleaseExtract("alt.binaries.multimedia.anime", "(?i)^.*?\"(?P<name>.*?(\\]|\\)))\\.", 1, false)
-
-	//High School DxD New 01 (480p|.avi|xvid|mp3) ~bY Hatsuyuki [01/18] - "[Hatsuyuki]_High_School_DxD_New_01_[848x480][76B2BB8C].avi.001" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `.+? \((360|480|720|1080)p\|.+? ~bY .+? \[\d+\/\d+\] - "(.+?\[[A-F0-9]+\].+?)`+e1, 2, true)
-	//([AST] One Piece Episode 301-350 [720p]) [007/340] - "One Piece episode 301-350.part006.rar" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `^\((\[.+?\] .+?)\) \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[REPOST][ New Doraemon 2013.05.03 Episode 328 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org ] [35/61] - "doraclub.org-doraemon-20130503-b8de1f8e.r32" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `^\[.+?\]\[ (.+?) \] \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[De.us] Suzumiya Haruhi no Shoushitsu (1920x1080 h.264 Dual-Audio FLAC 10-bit) [017CB24D] [000/357] - "[De.us] Suzumiya Haruhi no Shoushitsu (1920x1080 h.264 Dual-Audio FLAC 10-bit) [017CB24D].nzb" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `^(\[.+?\] [.+?] \[[A-F0-9]+\]) \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[eraser] Ghost in the Shell ARISE - border_1 Ghost Pain (BD 720p Hi444PP LC-AAC Stereo) - [01/65] - "[eraser] Ghost in the Shell ARISE - border_1 Ghost Pain (BD 720p Hi444PP LC-AAC Stereo) .md5" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `^\[.+?\] (.+?) - \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//(01/27) - Maid.Sama.Jap.dubbed.german.english.subbed - "01 Misaki ist eine Maid!.divx" - 6,44 GB - yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `^\(\d+\/\d+\) - (.+?) - ".+?" - \d+[,.]\d+ [mMkKgG][bB] - yEnc$`, 1, true)
-	//[ New Doraemon 2013.06.14 Episode 334 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org ] [01/60] - "doraclub.org-doraemon-20130614-fae28cec.nfo" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `^\[ (.+?) \] \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//<TOWN> www.town.ag > sponsored by www.ssl-news.info > (1/3) "HolzWerken_40.par2" - 43,89 MB - yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `^<TOWN> www\.town\.ag > sponsored by www\.ssl-news\.info > \(\d+\/\d+\) "(.+?)`+e0+` - \d+[,.]\d+ [mMkKgG][bB] - yEnc$`, 1, true)
-	//(1/9)<<<www.town.ag>>> sponsored by ssl-news.info<<<[HorribleSubs]_AIURA_-_01_[480p].mkv "[HorribleSubs]_AIURA_-_01_[480p].par2" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `^\(\d+\/\d+\).+?www\.town\.ag.+?sponsored by (www\.)?ssl-news\.info<+?.+? "(.+?)`+e1, 2, true)
-	//Overman King Gainer [Dual audio, EngSub] Exiled Destiny - [002/149] - "Overman King Gainer.part001.rar" yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `^(.+? \[Dual [aA]udio, EngSub\] .+?) - \[\d+\/\d+\] - ".+?" yEnc$`, 1, true)
-	//[ TOWN ]-[ www.town.ag ]-[ partner of www.ssl-news.info ]-[ MOVIE ] [14/19] - "Night.Vision.2011.DVDRip.x264-IGUANA.part12.rar" - 660,80 MB yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `(?i)^\[ TOWN \][ _-]{0,3}\[ www\.town\.ag \][ _-]{0,3}\[ partner of www\.ssl-news\.info \][ _-]{0,3}\[ .* \] \[\d+\/\d+\][ _-]{0,3}("|#34;)(.+)((\.part\d+\.rar)|(\.vol\d+\+\d+\.par2))("|#34;)[ _-]{0,3}\d+[.,]\d+ [kKmMgG][bB][ _-]{0,3}yEnc$`, 2, true)
-	//[ TOWN ]-[ www.town.ag ]-[ partner of www.ssl-news.info ]-[ MOVIE ] [01/84] - "The.Butterfly.Effect.2.2006.1080p.BluRay.x264-LCHD.par2" - 7,49 GB yEnc
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", `(?i)^\[ TOWN \][ _-]{0,3}\[ www\.town\.ag \][ _-]{0,3}\[ partner of www\.ssl-news\.info \][ _-]{0,3}\[ .* \] \[\d+\/\d+\][ _-]{0,3}("|#34;)(.+)\.(par2|rar|nfo|nzb)("|#34;)[ _-]{0,3}\d+[.,]\d+ [kKmMgG][bB][ _-]{0,3}yEnc$`, 2, true)
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", "(?i)^.*?\\.mkv.*?\"(?P<name>.*?)\\.mkv", 1, false)
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", "(?i)^(?P<name>.*?\\]) \\[(?P<parts>\\d{1,3}\\/\\d{1,3})", 1, false)
-	addReleaseExtract("alt.binaries.multimedia.anime.highspeed", "(?i)^.*?\"(?P<name>.*?(\\]|\\)))\\.", 1, false)
-}
-
 func partLess(subject string) string {
 	r := partLessA.ReplaceAllString(subject, "yEnc")
 	r = partLessB.ReplaceAllString(r, "yEnc")
 	return strings.TrimSpace(r)
 }
 
-func ExtractRelease(group string, subject string) string {
-	partlessSubject := partLess(subject)
-	if sm, ok := subjectMatchers[group]; ok {
-		for _, m := range sm {
-			s := subject
-			if m.usePartless {
-				s = partlessSubject
+// RuleRef identifies a rule by the name of its set and its position in
+// the set.
+type RuleRef struct {
+	Set  string `json:"set"`
+	Rule int    `json:"rule"`
+}
+
+func (r RuleRef) String() string {
+	return r.Set + "/" + strconv.Itoa(r.Rule)
+}
+
+// Rules returns the rules applying to group, in the order they are tried.
+func Rules(group string) []RuleRef {
+	sm := matchers().forGroup(group)
+	refs := make([]RuleRef, len(sm))
+	for i, m := range sm {
+		refs[i] = RuleRef{Set: m.set, Rule: m.rule}
+	}
+	return refs
+}
+
+// MatchRelease is ExtractRelease, but also returns the rule that matched.
+// ok is false if no rule matched.
+func MatchRelease(group string, subject string) (release string, rule RuleRef, ok bool) {
+	// The partless subject costs two regex replaces, only compute it once a
+	// rule needs it.
+	partlessSubject, partless := "", false
+	for _, m := range matchers().forGroup(group) {
+		s := subject
+		if m.usePartless {
+			if !partless {
+				partlessSubject, partless = partLess(subject), true
 			}
-			if res := m.regex.FindStringSubmatch(s); res != nil {
-				return res[m.subjectIndex]
+			s = partlessSubject
+		}
+		// compileRule checked the capture index is within the regex.
+		if res := m.regex.FindStringSubmatchIndex(s); res != nil {
+			if res[2*m.subjectIndex] < 0 {
+				return "", RuleRef{Set: m.set, Rule: m.rule}, true
 			}
+			return s[res[2*m.subjectIndex]:res[2*m.subjectIndex+1]], RuleRef{Set: m.set, Rule: m.rule}, true
 		}
 	}
-	return ""
+	return "", RuleRef{}, false
+}
+
+func ExtractRelease(group string, subject string) string {
+	release, _, _ := MatchRelease(group, subject)
+	return release
 }
 
 func ExtractFile(subject string) string {
@@ -160,20 +103,146 @@ func ExtractFile(subject string) string {
 	return ""
 }
 
-func ExtractYencPart(subject string) int {
+// Parts holds the counters of a subject. File and Files are the position
+// of the file in the release and the number of files in the release
+// ([07/340]), they are 0 if the subject has no file counter. Part and Total
+// are the segment of the file and the number of segments of the file
+// (yEnc (1/137)), they default to 1.
+type Parts struct {
+	File  int `json:"file"`
+	Files int `json:"files"`
+	Part  int `json:"part"`
+	Total int `json:"total"`
+}
+
+// ExtractParts finds the file and segment counters of subject. The segment
+// counter is the yEnc (n/m) counter, or else a (n/m), [n/m] or "n of m"
+// counter ending the subject. The file counter is the last counter before
+// the quoted filename, or before the segment counter if there is no
+// filename.
+func ExtractParts(subject string) Parts {
+	p := Parts{Part: 1, Total: 1}
+	head := subject
 	if res := yencMatch.FindStringSubmatch(subject); res != nil {
-		if r, e := strconv.Atoi(res[3]); e == nil {
-			return r
+		if part, total, ok := parseCounter(res[3], res[4]); ok {
+			p.Part, p.Total = part, total
+		}
+		head = res[1]
+	} else if res := segmentCountMatch.FindStringSubmatchIndex(subject); res != nil {
+		if part, total, ok := parseCounter(subject[res[2]:res[3]], subject[res[4]:res[5]]); ok {
+			p.Part, p.Total = part, total
 		}
+		head = subject[:res[0]]
 	}
-	return 1
+	if i := strings.IndexByte(head, '"'); i >= 0 {
+		head = head[:i]
+	}
+	if res := fileCountMatch.FindAllStringSubmatch(head, -1); res != nil {
+		last := res[len(res)-1]
+		if file, files, ok := parseCounter(last[2], last[6]); ok {
+			p.File, p.Files = file, files
+		}
+	}
+	return p
+}
+
+func parseCounter(n string, m string) (int, int, bool) {
+	i, err := strconv.Atoi(n)
+	if err != nil {
+		return 0, 0, false
+	}
+	j, err := strconv.Atoi(m)
+	if err != nil || i > j {
+		return 0, 0, false
+	}
+	return i, j, true
+}
+
+// Subject is everything extracted from an article subject.
+type Subject struct {
+	Release  string  `json:"release"`
+	Rule     RuleRef `json:"rule"`
+	Filename string  `json:"filename"`
+	Parts    Parts   `json:"parts"`
+	// Matched is false if no rule matched the subject.
+	Matched bool `json:"matched"`
+}
+
+// ExtractSubject runs the release, filename and counter extraction on
+// subject in one go, so callers needing more than one of them only extract
+// once.
+func ExtractSubject(group string, subject string) Subject {
+	release, rule, matched := MatchRelease(group, subject)
+	return Subject{
+		Release:  release,
+		Rule:     rule,
+		Matched:  matched,
+		Filename: ExtractFile(subject),
+		Parts:    ExtractParts(subject),
+	}
+}
+
+func ExtractYencPart(subject string) int {
+	return ExtractParts(subject).Part
 }
 
 func ExtractYencLength(subject string) int {
-	if res := yencMatch.FindStringSubmatch(subject); res != nil {
-		if r, e := strconv.Atoi(res[4]); e == nil {
-			return r
+	return ExtractParts(subject).Total
+}
+
+// RuleTrial is the outcome of running one rule against a subject.
+type RuleTrial struct {
+	Rule     RuleRef  `json:"rule"`
+	Regex    string   `json:"regex"`
+	Partless bool     `json:"partless"`
+	Matched  bool     `json:"matched"`
+	Captures []string `json:"captures,omitempty"`
+	Index    int      `json:"index"`
+	Claimed  bool     `json:"claimed"`
+}
+
+// Explanation describes how ExtractRelease handles a subject.
+type Explanation struct {
+	Group           string      `json:"group"`
+	Subject         string      `json:"subject"`
+	PartlessSubject string      `json:"partless_subject"`
+	Release         string      `json:"release"`
+	Rules           []RuleTrial `json:"rules"`
+}
+
+// Explain runs every rule applying to group against subject. Rules after the
+// one claiming the subject are run as well, so shadowed rules show up as
+// matched but not claimed.
+func Explain(group string, subject string) Explanation {
+	e := Explanation{
+		Group:           group,
+		Subject:         subject,
+		PartlessSubject: partLess(subject),
+	}
+	sm := matchers().forGroup(group)
+	e.Rules = make([]RuleTrial, len(sm))
+	claimed := false
+	for i, m := range sm {
+		s := subject
+		if m.usePartless {
+			s = e.PartlessSubject
+		}
+		trial := RuleTrial{
+			Rule:     RuleRef{Set: m.set, Rule: m.rule},
+			Regex:    m.regex.String(),
+			Partless: m.usePartless,
+			Index:    m.subjectIndex,
+		}
+		if res := m.regex.FindStringSubmatch(s); res != nil {
+			trial.Matched = true
+			trial.Captures = res
+			if !claimed {
+				trial.Claimed = true
+				claimed = true
+				e.Release = res[m.subjectIndex]
+			}
 		}
+		e.Rules[i] = trial
 	}
-	return 1
+	return e
 }
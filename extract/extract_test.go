@@ -0,0 +1,208 @@
+package extract
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+type subjectCase struct {
+	Group    string `json:"group"`
+	Subject  string `json:"subject"`
+	Release  string `json:"release"`
+	Filename string `json:"filename"`
+	File     int    `json:"file"`
+	Files    int    `json:"files"`
+	Part     int    `json:"part"`
+	Total    int    `json:"total"`
+}
+
+func loadCorpus(t testing.TB) []subjectCase {
+	data, err := ioutil.ReadFile("testdata/subjects.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []subjectCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatal(err)
+	}
+	return cases
+}
+
+func TestCorpus(t *testing.T) {
+	for _, c := range loadCorpus(t) {
+		if r := ExtractRelease(c.Group, c.Subject); r != c.Release {
+			t.Errorf("ExtractRelease(%q, %q) = %q, want %q", c.Group, c.Subject, r, c.Release)
+		}
+		if f := ExtractFile(c.Subject); f != c.Filename {
+			t.Errorf("ExtractFile(%q) = %q, want %q", c.Subject, f, c.Filename)
+		}
+		if p := ExtractYencPart(c.Subject); p != c.Part {
+			t.Errorf("ExtractYencPart(%q) = %d, want %d", c.Subject, p, c.Part)
+		}
+		if l := ExtractYencLength(c.Subject); l != c.Total {
+			t.Errorf("ExtractYencLength(%q) = %d, want %d", c.Subject, l, c.Total)
+		}
+		want := Parts{File: c.File, Files: c.Files, Part: c.Part, Total: c.Total}
+		if p := ExtractParts(c.Subject); p != want {
+			t.Errorf("ExtractParts(%q) = %+v, want %+v", c.Subject, p, want)
+		}
+	}
+}
+
+func TestCorpusCoversGroups(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, c := range loadCorpus(t) {
+		if c.Release != "" {
+			covered[c.Group] = true
+		}
+	}
+	for _, set := range defaultRules.Sets {
+		for _, g := range set.Groups {
+			if !covered[g] {
+				t.Errorf("No matched subject for %s in testdata/subjects.json", g)
+			}
+		}
+	}
+}
+
+func TestRuleExamples(t *testing.T) {
+	for _, set := range defaultRules.Sets {
+		for i, rule := range set.Rules {
+			if rule.Example == "" {
+				continue
+			}
+			for _, g := range set.Groups {
+				if ExtractRelease(g, rule.Example) == "" {
+					t.Errorf("Example of rule %s/%d does not match in %s", set.Name, i, g)
+				}
+			}
+		}
+	}
+}
+
+func TestMatchRelease(t *testing.T) {
+	subject := `([AST] One Piece Episode 301-350 [720p]) [007/340] - "One Piece episode 301-350.part006.rar" yEnc (12/137)`
+	release, rule, ok := MatchRelease("alt.binaries.anime", subject)
+	if !ok || release != "[AST] One Piece Episode 301-350 [720p]" {
+		t.Fatalf("MatchRelease returned %q, %v", release, ok)
+	}
+	if rule != (RuleRef{Set: "anime", Rule: 0}) {
+		t.Errorf("Matched by %s, want anime/0", rule)
+	}
+	if _, rule, _ := MatchRelease("alt.binaries.teevee", subject); rule.Set != "fallback" {
+		t.Errorf("Matched by %s in a group without rules, want a fallback rule", rule)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	defer SetFallback(nil)
+	for _, rule := range fallbackRules.Rules {
+		if r := ExtractRelease("alt.binaries.teevee", rule.Example); r != "Some.Show.S01E05.720p.HDTV.x264-GRP" {
+			t.Errorf("Fallback extracted %q from %q", r, rule.Example)
+		}
+	}
+
+	subject := `a8f3e1c9b2d7 [1/5] - "Random.Upload.part1.rar" yEnc (1/50)`
+	if r := ExtractRelease("alt.binaries.anime", subject); r != "" {
+		t.Errorf("Fallback used in a group with rules, got %q", r)
+	}
+	SetFallback(map[string]bool{"alt.binaries.*": true, "alt.binaries.teevee": false})
+	if r := ExtractRelease("alt.binaries.anime", subject); r != "Random.Upload" {
+		t.Errorf("Fallback not used when enabled, got %q", r)
+	}
+	if r := ExtractRelease("alt.binaries.teevee", subject); r != "" {
+		t.Errorf("Fallback used when disabled, got %q", r)
+	}
+}
+
+func FuzzExtractRelease(f *testing.F) {
+	for _, c := range loadCorpus(f) {
+		f.Add(c.Group, c.Subject)
+	}
+	f.Fuzz(func(t *testing.T, group string, subject string) {
+		ExtractRelease(group, subject)
+		ExtractFile(subject)
+	})
+}
+
+func FuzzExtractYencPart(f *testing.F) {
+	for _, c := range loadCorpus(f) {
+		f.Add(c.Subject)
+	}
+	f.Fuzz(func(t *testing.T, subject string) {
+		p := ExtractParts(subject)
+		if p.Part < 0 || p.Part > p.Total || p.File < 0 || p.File > p.Files {
+			t.Errorf("ExtractParts(%q) = %+v", subject, p)
+		}
+	})
+}
+
+func TestExplain(t *testing.T) {
+	for _, c := range loadCorpus(t) {
+		e := Explain(c.Group, c.Subject)
+		if e.Release != c.Release {
+			t.Errorf("Explain(%q, %q).Release = %q, want %q", c.Group, c.Subject, e.Release, c.Release)
+		}
+		claimed := 0
+		for _, trial := range e.Rules {
+			if trial.Claimed {
+				claimed++
+				if trial.Captures[trial.Index] != c.Release {
+					t.Errorf("Rule %s claimed %q but captured %q", trial.Rule, c.Subject, trial.Captures[trial.Index])
+				}
+			}
+		}
+		if (claimed == 1) != (c.Release != "") {
+			t.Errorf("%d rules claimed %q", claimed, c.Subject)
+		}
+	}
+}
+
+func benchmarkSubjects(b *testing.B) []subjectCase {
+	cases := loadCorpus(b)
+	b.ResetTimer()
+	return cases
+}
+
+func BenchmarkExtractRelease(b *testing.B) {
+	cases := benchmarkSubjects(b)
+	for i := 0; i < b.N; i++ {
+		c := cases[i%len(cases)]
+		ExtractRelease(c.Group, c.Subject)
+	}
+}
+
+func BenchmarkExtractParts(b *testing.B) {
+	cases := benchmarkSubjects(b)
+	for i := 0; i < b.N; i++ {
+		ExtractParts(cases[i%len(cases)].Subject)
+	}
+}
+
+// BenchmarkExtractPerArticle is the extraction an article used to cost the
+// elasticsearch sink, which extracted on Accept and again for every id and
+// document it built.
+func BenchmarkExtractPerArticle(b *testing.B) {
+	cases := benchmarkSubjects(b)
+	for i := 0; i < b.N; i++ {
+		c := cases[i%len(cases)]
+		ExtractRelease(c.Group, c.Subject)
+		ExtractRelease(c.Group, c.Subject)
+		ExtractRelease(c.Group, c.Subject)
+		ExtractFile(c.Subject)
+		ExtractFile(c.Subject)
+		ExtractYencLength(c.Subject)
+		ExtractYencPart(c.Subject)
+	}
+}
+
+// BenchmarkExtractSubject is the extraction an article costs the
+// elasticsearch sink now that it is done once per article.
+func BenchmarkExtractSubject(b *testing.B) {
+	cases := benchmarkSubjects(b)
+	for i := 0; i < b.N; i++ {
+		c := cases[i%len(cases)]
+		ExtractSubject(c.Group, c.Subject)
+	}
+}
@@ -0,0 +1,117 @@
+package extract
+
+import (
+	"regexp"
+	"strings"
+)
+
+var counterMatch *regexp.Regexp
+var tokenSplit *regexp.Regexp
+
+// boilerplateWords are common in subjects of obfuscated posts and say nothing
+// about their content.
+var boilerplateWords = map[string]bool{
+	"yenc": true, "part": true, "vol": true, "rar": true, "par": true,
+	"nzb": true, "nfo": true, "sfv": true, "zip": true, "mkv": true,
+	"avi": true, "mp4": true, "bin": true, "iso": true, "rev": true,
+	"file": true, "of": true,
+}
+
+func init() {
+	counterMatch = regexp.MustCompile(`[\(\[]\d+\/\d+[\)\]]`)
+	tokenSplit = regexp.MustCompile(`[^A-Za-z0-9]+`)
+}
+
+func isHex(t string) bool {
+	for i := 0; i < len(t); i++ {
+		c := t[i]
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')) {
+			return false
+		}
+	}
+	return true
+}
+
+// isRandomToken reports whether t looks like a hash or a base32/base64
+// encoded string rather than a word.
+func isRandomToken(t string) bool {
+	if len(t) < 12 {
+		return false
+	}
+	if isHex(t) {
+		return true
+	}
+	letters, digits, vowels, caseChanges := 0, 0, 0, 0
+	for i := 0; i < len(t); i++ {
+		c := t[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		default:
+			letters++
+			if strings.IndexByte("aeiouyAEIOUY", c) >= 0 {
+				vowels++
+			}
+			if i > 0 && isUpper(c) != isUpper(t[i-1]) && !(t[i-1] >= '0' && t[i-1] <= '9') {
+				caseChanges++
+			}
+		}
+	}
+	if digits > 0 && letters > 0 && digits*8 >= len(t) {
+		return true
+	}
+	return caseChanges >= 4 || vowels*5 < letters
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+// isHumanToken reports whether t looks like a word: letters only, with
+// vowels, and in lower, upper or title case.
+func isHumanToken(t string) bool {
+	if len(t) < 3 || boilerplateWords[strings.ToLower(t)] {
+		return false
+	}
+	vowels := 0
+	for i := 0; i < len(t); i++ {
+		c := t[i]
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+			return false
+		}
+		if strings.IndexByte("aeiouyAEIOUY", c) >= 0 {
+			vowels++
+		}
+	}
+	rest := t[1:]
+	if rest != strings.ToLower(rest) && rest != strings.ToUpper(rest) {
+		return false
+	}
+	return vowels > 0
+}
+
+// IsObfuscated reports whether subject looks like an obfuscated post, ie. a
+// random hash as subject or filename, without any human readable words. It
+// is meant for subjects no rule claimed, see Subject.Claimed.
+func IsObfuscated(subject string) bool {
+	s := counterMatch.ReplaceAllString(subject, " ")
+	random := false
+	for _, t := range tokenSplit.Split(s, -1) {
+		if isRandomToken(t) {
+			random = true
+		} else if isHumanToken(t) {
+			return false
+		}
+	}
+	return random
+}
+
+// Claimed reports whether a rule claimed the subject. The fallback rules
+// match the filename of about any post, they only claim releases that don't
+// look obfuscated, so hashed filenames are left to IsObfuscated.
+func (s Subject) Claimed() bool {
+	if !s.Matched {
+		return false
+	}
+	return s.Rule.Set != fallbackRules.Name || !IsObfuscated(s.Release)
+}
@@ -0,0 +1,49 @@
+package extract
+
+import (
+	"testing"
+)
+
+func TestIsObfuscated(t *testing.T) {
+	cases := []struct {
+		subject string
+		want    bool
+	}{
+		{`a8f3e1c9b2d74e6f8a1b3c5d7e9f0a2b yEnc (1/50)`, true},
+		{`[1/12] - "d41d8cd98f00b204e9800998ecf8427e.part01.rar" yEnc (3/100)`, true},
+		{`"MFRGGZDFMZTWQ2LKNNWG23TPOBYXE43U.vol01+02.par2" yEnc (1/3)`, true},
+		{`"xK9mQ2pL7vN3wR8t.7z.001" yEnc (12/70)`, true},
+		{`"qwhdkslfjrtzx.rar" yEnc (1/2)`, true},
+		{`([AST] One Piece Episode 301-350 [720p]) [007/340] - "One Piece episode 301-350.part006.rar" yEnc (12/137)`, false},
+		{`[HorribleSubs] Shingeki no Kyojin - 05 [720p].mkv "[HorribleSubs] Shingeki no Kyojin - 05 [720p].mkv" yEnc (14/450)`, false},
+		{`[Commie] Kill la Kill - 12 [3B8F1A2C] [01/25] - "[Commie] Kill la Kill - 12 [3B8F1A2C].mkv" yEnc (1/340)`, false},
+		{`Some.Show.S01E05.720p.HDTV.x264-GRP.r07 yEnc (3/50)`, false},
+		{`Re: missing parts for one piece`, false},
+	}
+	for _, c := range cases {
+		if o := IsObfuscated(c.subject); o != c.want {
+			t.Errorf("IsObfuscated(%q) = %v, want %v", c.subject, o, c.want)
+		}
+	}
+}
+
+func TestSubjectClaimed(t *testing.T) {
+	cases := []struct {
+		group   string
+		subject string
+		want    bool
+	}{
+		// A rule of the group.
+		{"alt.binaries.anime", `([AST] One Piece Episode 301-350 [720p]) [007/340] - "One Piece episode 301-350.part006.rar" yEnc`, true},
+		// No rule of the group.
+		{"alt.binaries.anime", `a8f3e1c9b2d74e6f8a1b3c5d7e9f0a2b yEnc (1/50)`, false},
+		// The fallback rules of a group without rules.
+		{"alt.binaries.teevee", `"Some.Show.S01E05.720p.HDTV.x264-GRP.part01.rar" yEnc (3/100)`, true},
+		{"alt.binaries.teevee", `"d41d8cd98f00b204e9800998ecf8427e.part01.rar" yEnc (3/100)`, false},
+	}
+	for _, c := range cases {
+		if claimed := ExtractSubject(c.group, c.subject).Claimed(); claimed != c.want {
+			t.Errorf("ExtractSubject(%q, %q).Claimed() = %v, want %v", c.group, c.subject, claimed, c.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package extract
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ReleaseInfo is a release name broken down into the parts commonly found in
+// anime release names. Fields that could not be found are left empty.
+type ReleaseInfo struct {
+	Name       string   `json:"name"`
+	Group      string   `json:"group,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Episode    string   `json:"episode,omitempty"`
+	Resolution string   `json:"resolution,omitempty"`
+	Crc        string   `json:"crc,omitempty"`
+	Codecs     []string `json:"codec,omitempty"`
+}
+
+var leadingTagsMatch *regexp.Regexp
+var groupTagMatch *regexp.Regexp
+var bracketsMatch *regexp.Regexp
+var crcMatch *regexp.Regexp
+var resolutionMatch *regexp.Regexp
+var dimensionsMatch *regexp.Regexp
+var codecMatch *regexp.Regexp
+var episodeMatches []*regexp.Regexp
+
+func init() {
+	leadingTagsMatch = regexp.MustCompile(`^[\s_(]*(\[[^\]]*\][\s_]*)+`)
+	groupTagMatch = regexp.MustCompile(`\[([^\]]+)\]`)
+	bracketsMatch = regexp.MustCompile(`\[[^\]]*\]|\([^\)]*\)`)
+	crcMatch = regexp.MustCompile(`[\[\(]([A-Fa-f0-9]{8})[\]\)]`)
+	resolutionMatch = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(360|480|576|720|1080|2160)([pi])(?:[^a-z0-9]|$)`)
+	dimensionsMatch = regexp.MustCompile(`(?:^|[^0-9])\d{3,4}x(360|480|576|720|1080|2160)(?:[^0-9]|$)`)
+	codecMatch = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(h\.?26[45]|x26[45]|hevc|avc|xvid|divx|hi10p?|hi444pp|10-?bit|flac|aac|ac3|dts|mp3|opus|vorbis|mpeg2)`)
+	episodeMatches = []*regexp.Regexp{
+		// Show.S01E05.720p
+		regexp.MustCompile(`(?i)(?:^|[^a-z0-9])S\d{1,2}E(\d{1,4})(?:[^0-9]|$)`),
+		// One Piece Episode 301-350
+		regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:episode|ep)\.?\s*(\d{1,4}(?:-\d{1,4})?)(?:[^0-9]|$)`),
+		// [HorribleSubs] AIURA - 01 [480p]
+		regexp.MustCompile(`(?:^|\s)-\s(\d{1,4})(?:v\d)?(?:\s|$)`),
+		// High School DxD New 01 [848x480]
+		regexp.MustCompile(`\s(\d{2,4})(?:v\d)?\s*(?:[\[\(]|$)`),
+	}
+}
+
+// normalizeSeparators replaces underscores with spaces, and dots as well if
+// the name has no spaces at all (ie. Night.Vision.2011.DVDRip.x264-IGUANA).
+func normalizeSeparators(s string) string {
+	s = strings.Replace(s, "_", " ", -1)
+	if !strings.Contains(s, " ") {
+		s = strings.Replace(s, ".", " ", -1)
+	}
+	return s
+}
+
+func isReleaseTag(tag string) bool {
+	if crcMatch.MatchString("[" + tag + "]") {
+		return false
+	}
+	if resolutionMatch.MatchString(tag) || dimensionsMatch.MatchString(tag) {
+		return false
+	}
+	switch strings.ToLower(tag) {
+	case "repost", "re-post", "reup", "tv", "bd", "dvd":
+		return false
+	}
+	return strings.TrimSpace(tag) != ""
+}
+
+func isAlnum(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func findEpisode(s string) (string, int) {
+	for _, m := range episodeMatches {
+		if res := m.FindStringSubmatchIndex(s); res != nil {
+			ep := s[res[2]:res[3]]
+			parts := strings.Split(ep, "-")
+			for i, p := range parts {
+				if n, err := strconv.Atoi(p); err == nil {
+					parts[i] = strconv.Itoa(n)
+				}
+			}
+			return strings.Join(parts, "-"), res[0]
+		}
+	}
+	return "", -1
+}
+
+func parseReleaseInto(info *ReleaseInfo, s string) {
+	if info.Group == "" {
+		if lead := leadingTagsMatch.FindString(s); lead != "" {
+			for _, tag := range groupTagMatch.FindAllStringSubmatch(lead, -1) {
+				if isReleaseTag(tag[1]) {
+					info.Group = tag[1]
+					break
+				}
+			}
+		}
+	}
+	if info.Crc == "" {
+		if res := crcMatch.FindStringSubmatch(s); res != nil {
+			info.Crc = strings.ToUpper(res[1])
+		}
+	}
+	if info.Resolution == "" {
+		if res := resolutionMatch.FindStringSubmatch(s); res != nil {
+			// Interlaced releases keep their i.
+			info.Resolution = res[1] + strings.ToLower(res[2])
+		} else if res := dimensionsMatch.FindStringSubmatch(s); res != nil {
+			info.Resolution = res[1] + "p"
+		}
+	}
+	ns := normalizeSeparators(s)
+	for _, res := range codecMatch.FindAllStringSubmatchIndex(ns, -1) {
+		// The trailing boundary is checked here so it is not consumed,
+		// adjacent codecs (ie. "MPEG2 AAC") share the separator.
+		if res[1] < len(ns) && isAlnum(ns[res[1]]) {
+			continue
+		}
+		codec := strings.ToLower(ns[res[2]:res[3]])
+		dup := false
+		for _, c := range info.Codecs {
+			if c == codec {
+				dup = true
+			}
+		}
+		if !dup {
+			info.Codecs = append(info.Codecs, codec)
+		}
+	}
+
+	body := normalizeSeparators(s[len(leadingTagsMatch.FindString(s)):])
+	episode, at := findEpisode(body)
+	if info.Episode == "" {
+		info.Episode = episode
+	}
+	if info.Title == "" {
+		title := body
+		if at >= 0 {
+			title = body[:at]
+		}
+		title = bracketsMatch.ReplaceAllString(title, " ")
+		if res := resolutionMatch.FindStringIndex(title); res != nil {
+			title = title[:res[0]]
+		}
+		info.Title = strings.Trim(strings.Join(strings.Fields(title), " "), " -")
+	}
+}
+
+// ParseRelease breaks a release name down into a ReleaseInfo. The filename of
+// one of the release's files, if known, is used to fill in the parts missing
+// from the release name.
+func ParseRelease(name string, filename string) ReleaseInfo {
+	info := ReleaseInfo{Name: name}
+	if name == "" {
+		return info
+	}
+	parseReleaseInto(&info, name)
+	if filename != "" {
+		parseReleaseInto(&info, filename)
+	}
+	return info
+}
+
+// ExtractReleaseInfo extracts the release name and filename from subject and
+// parses them into a ReleaseInfo. Name is empty if the subject matched none
+// of the group's rules.
+func ExtractReleaseInfo(group string, subject string) ReleaseInfo {
+	return ParseRelease(ExtractRelease(group, subject), ExtractFile(subject))
+}
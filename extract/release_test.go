@@ -0,0 +1,83 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRelease(t *testing.T) {
+	cases := []struct {
+		name     string
+		filename string
+		want     ReleaseInfo
+	}{
+		{
+			"[HorribleSubs]_AIURA_-_01_[480p]", "[HorribleSubs]_AIURA_-_01_[480p].par2",
+			ReleaseInfo{Group: "HorribleSubs", Title: "AIURA", Episode: "1", Resolution: "480p"},
+		},
+		{
+			"[De.us] Suzumiya Haruhi no Shoushitsu (1920x1080 h.264 Dual-Audio FLAC 10-bit) [017CB24D]", "",
+			ReleaseInfo{Group: "De.us", Title: "Suzumiya Haruhi no Shoushitsu", Resolution: "1080p", Crc: "017CB24D", Codecs: []string{"h.264", "flac", "10-bit"}},
+		},
+		{
+			"[AST] One Piece Episode 301-350 [720p]", "One Piece episode 301-350.part006.rar",
+			ReleaseInfo{Group: "AST", Title: "One Piece", Episode: "301-350", Resolution: "720p"},
+		},
+		{
+			"Ghost in the Shell ARISE - border_1 Ghost Pain (BD 720p Hi444PP LC-AAC Stereo)", "[eraser] Ghost in the Shell ARISE - border_1 Ghost Pain (BD 720p Hi444PP LC-AAC Stereo) .md5",
+			ReleaseInfo{Group: "eraser", Title: "Ghost in the Shell ARISE - border 1 Ghost Pain", Resolution: "720p", Codecs: []string{"hi444pp", "aac"}},
+		},
+		{
+			"[Commie] Kill la Kill - 12 [3B8F1A2C]", "[Commie] Kill la Kill - 12 [3B8F1A2C].mkv",
+			ReleaseInfo{Group: "Commie", Title: "Kill la Kill", Episode: "12", Crc: "3B8F1A2C"},
+		},
+		{
+			"Show.Name.S01E05.720p.HDTV.x264-GRP", "",
+			ReleaseInfo{Title: "Show Name", Episode: "5", Resolution: "720p", Codecs: []string{"x264"}},
+		},
+		{
+			"Show.Name.S02E01.1080i.HDTV.MPEG2-GRP", "",
+			ReleaseInfo{Title: "Show Name", Episode: "1", Resolution: "1080i", Codecs: []string{"mpeg2"}},
+		},
+		{
+			"New Doraemon 2013.05.03 Episode 328 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org", "",
+			ReleaseInfo{Title: "New Doraemon 2013.05.03", Episode: "328", Resolution: "1080i", Codecs: []string{"mpeg2", "aac"}},
+		},
+		{
+			// Resolution tags aren't groups, and are lower cased.
+			"[1080i] [Raws] Some Show - 03 [1080I]", "",
+			ReleaseInfo{Group: "Raws", Title: "Some Show", Episode: "3", Resolution: "1080i"},
+		},
+		{
+			"Some.Show.S01E02.720P.WEB", "",
+			ReleaseInfo{Title: "Some Show", Episode: "2", Resolution: "720p"},
+		},
+		{
+			"", "[Commie] Kill la Kill - 12 [3B8F1A2C].mkv",
+			ReleaseInfo{},
+		},
+	}
+	for _, c := range cases {
+		c.want.Name = c.name
+		if info := ParseRelease(c.name, c.filename); !reflect.DeepEqual(info, c.want) {
+			t.Errorf("ParseRelease(%q, %q) = %+v, want %+v", c.name, c.filename, info, c.want)
+		}
+	}
+}
+
+func TestExtractReleaseInfo(t *testing.T) {
+	subject := `[REPOST][ New Doraemon 2013.05.03 Episode 328 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org ] [35/61] - "doraclub.org-doraemon-20130503-b8de1f8e.r32" yEnc`
+	want := ReleaseInfo{
+		Name:       "New Doraemon 2013.05.03 Episode 328 (TV Asahi) 1080i HDTV MPEG2 AAC-DoraClub.org",
+		Title:      "New Doraemon 2013.05.03",
+		Episode:    "328",
+		Resolution: "1080i",
+		Codecs:     []string{"mpeg2", "aac"},
+	}
+	if info := ExtractReleaseInfo("alt.binaries.anime", subject); !reflect.DeepEqual(info, want) {
+		t.Errorf("ExtractReleaseInfo(%q) = %+v, want %+v", subject, info, want)
+	}
+	if info := ExtractReleaseInfo("alt.binaries.anime", "Re: missing parts"); !reflect.DeepEqual(info, ReleaseInfo{}) {
+		t.Errorf("Subject no rule matched parsed into %+v", info)
+	}
+}
@@ -0,0 +1,334 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// Rule describes a single release matcher. The release name is taken from
+// the capture group named Capture, or from the capture at Index if Capture
+// is empty. When Partless is set the rule is run against the subject with
+// its part counters and yEnc suffix normalized.
+type Rule struct {
+	Example  string   `json:"example,omitempty"`
+	Regex    string   `json:"regex"`
+	Index    int      `json:"index,omitempty"`
+	Capture  string   `json:"capture,omitempty"`
+	Partless bool     `json:"partless"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// RuleSet is an ordered list of rules shared by every newsgroup matching one
+// of Groups. Groups may be globs, ie. "alt.binaries.multimedia.anime*".
+type RuleSet struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+	Rules  []Rule   `json:"rules"`
+}
+
+// RuleFile is the on disk format of an extraction rule file.
+type RuleFile struct {
+	Sets []RuleSet `json:"sets"`
+}
+
+type releaseExtract struct {
+	regex        *regexp.Regexp
+	subjectIndex int
+	usePartless  bool
+	groups       []string
+	set          string
+	rule         int
+}
+
+func (r *releaseExtract) appliesTo(group string) bool {
+	for _, g := range r.groups {
+		if ok, _ := path.Match(g, group); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type matcherTable struct {
+	rules []releaseExtract
+
+	fallback       []releaseExtract
+	fallbackGroups map[string]bool
+
+	byGroup     map[string][]releaseExtract
+	byGroupLock sync.RWMutex
+}
+
+func newMatcherTable(rules []releaseExtract) *matcherTable {
+	return &matcherTable{
+		rules:   rules,
+		byGroup: make(map[string][]releaseExtract),
+	}
+}
+
+// forGroup returns the ordered rules applying to group. Glob matching is done
+// once per group, the result is cached on the table.
+func (t *matcherTable) forGroup(group string) []releaseExtract {
+	t.byGroupLock.RLock()
+	sm, ok := t.byGroup[group]
+	t.byGroupLock.RUnlock()
+	if ok {
+		return sm
+	}
+	sm = make([]releaseExtract, 0, 16)
+	for _, r := range t.rules {
+		if r.appliesTo(group) {
+			sm = append(sm, r)
+		}
+	}
+	if t.useFallback(group, len(sm) > 0) {
+		sm = append(sm, t.fallback...)
+	}
+	t.byGroupLock.Lock()
+	t.byGroup[group] = sm
+	t.byGroupLock.Unlock()
+	return sm
+}
+
+// useFallback reports whether the fallback rules are tried for group. An
+// exact entry in fallbackGroups wins over globs, the longest matching glob
+// wins over shorter ones. Groups without an entry only use the fallback
+// rules if they have no rules of their own.
+func (t *matcherTable) useFallback(group string, hasRules bool) bool {
+	if enabled, ok := t.fallbackGroups[group]; ok {
+		return enabled
+	}
+	best := ""
+	enabled := !hasRules
+	for g, e := range t.fallbackGroups {
+		if ok, _ := path.Match(g, group); ok && len(g) >= len(best) {
+			if len(g) > len(best) || g < best {
+				best = g
+				enabled = e
+			}
+		}
+	}
+	return enabled
+}
+
+func compileRule(set RuleSet, idx int) (releaseExtract, error) {
+	rule := set.Rules[idx]
+	r := releaseExtract{
+		usePartless: rule.Partless,
+		groups:      set.Groups,
+		set:         set.Name,
+		rule:        idx,
+	}
+	if len(rule.Groups) > 0 {
+		r.groups = rule.Groups
+	}
+	if len(r.groups) == 0 {
+		return r, fmt.Errorf("Rule applies to no newsgroups.")
+	}
+	for _, g := range r.groups {
+		if _, err := path.Match(g, ""); err != nil {
+			return r, fmt.Errorf("Bad newsgroup pattern %q.", g)
+		}
+	}
+	re, err := regexp.Compile(rule.Regex)
+	if err != nil {
+		return r, err
+	}
+	r.regex = re
+	if rule.Capture != "" {
+		r.subjectIndex = -1
+		for i, name := range re.SubexpNames() {
+			if name == rule.Capture {
+				r.subjectIndex = i
+				break
+			}
+		}
+		if r.subjectIndex < 0 {
+			return r, fmt.Errorf("Regex has no capture group named %q.", rule.Capture)
+		}
+	} else {
+		if rule.Index < 1 {
+			return r, fmt.Errorf("Rule must set a capture index or name.")
+		}
+		if rule.Index > re.NumSubexp() {
+			return r, fmt.Errorf("Capture index %d out of range, regex has %d capture groups.", rule.Index, re.NumSubexp())
+		}
+		r.subjectIndex = rule.Index
+	}
+	return r, nil
+}
+
+func compileRuleFile(rf RuleFile) ([]releaseExtract, error) {
+	rules := make([]releaseExtract, 0, 64)
+	for si, set := range rf.Sets {
+		for ri := range set.Rules {
+			r, err := compileRule(set, ri)
+			if err != nil {
+				return nil, &RuleError{Set: si, Rule: ri, Err: err}
+			}
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// RuleError reports an invalid rule. Line is only known for rules parsed
+// from a file.
+type RuleError struct {
+	File string
+	Line int
+	Set  int
+	Rule int
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s:%d: Invalid rule %d in set %d. (%s)", e.File, e.Line, e.Rule, e.Set, e.Err.Error())
+	}
+	return fmt.Sprintf("Invalid rule %d in set %d. (%s)", e.Rule, e.Set, e.Err.Error())
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte{'\n'}) + 1
+}
+
+// objectLines returns the line every JSON object in data starts on, keyed by
+// its path in the document, ie. "/sets/0/rules/3".
+func objectLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var walk func(p string) error
+	walk = func(p string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			lines[p] = lineAt(data, dec.InputOffset())
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if err := walk(p + "/" + fmt.Sprint(key)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(p + "/" + strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	walk("")
+	return lines
+}
+
+func parseRules(filename string, data []byte) ([]releaseExtract, error) {
+	var rf RuleFile
+	if err := json.Unmarshal(data, &rf); err != nil {
+		if serr, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("%s:%d: %s", filename, lineAt(data, serr.Offset), err.Error())
+		}
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	rules, err := compileRuleFile(rf)
+	if rerr, ok := err.(*RuleError); ok {
+		rerr.File = filename
+		rerr.Line = objectLines(data)[fmt.Sprintf("/sets/%d/rules/%d", rerr.Set, rerr.Rule)]
+	}
+	return rules, err
+}
+
+var baseRules []releaseExtract
+var overlayRules []releaseExtract
+var fallback []releaseExtract
+var fallbackGroups map[string]bool
+var rulesLock sync.Mutex
+
+func matchers() *matcherTable {
+	return subjectMatchers.Load().(*matcherTable)
+}
+
+// installRules publishes a new matcher table built from the overlay rules
+// followed by the base rules. Callers must hold rulesLock, except from init.
+func installRules() {
+	rules := make([]releaseExtract, 0, len(overlayRules)+len(baseRules))
+	rules = append(rules, overlayRules...)
+	rules = append(rules, baseRules...)
+	table := newMatcherTable(rules)
+	table.fallback = fallback
+	table.fallbackGroups = fallbackGroups
+	subjectMatchers.Store(table)
+}
+
+func readRuleFile(filename string) ([]releaseExtract, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(filename, data)
+}
+
+// LoadRuleFile replaces the compiled-in release matchers with the rules in
+// the JSON rule file at filename. The active rules are left untouched if any
+// rule in the file is invalid.
+func LoadRuleFile(filename string) error {
+	rules, err := readRuleFile(filename)
+	if err != nil {
+		return err
+	}
+	rulesLock.Lock()
+	baseRules = rules
+	installRules()
+	rulesLock.Unlock()
+	return nil
+}
+
+// LoadOverlayFile loads the rules in filename on top of the base rules, so
+// they are tried first. Any previously loaded overlay is replaced. It is safe
+// to call while extraction is running, the active rules are left untouched if
+// any rule in the file is invalid.
+func LoadOverlayFile(filename string) error {
+	rules, err := readRuleFile(filename)
+	if err != nil {
+		return err
+	}
+	rulesLock.Lock()
+	overlayRules = rules
+	installRules()
+	rulesLock.Unlock()
+	return nil
+}
+
+// SetFallback sets for which newsgroups the generic fallback rules are tried
+// after the newsgroup's own rules. Keys may be globs. By default only
+// newsgroups without any rules of their own use the fallback rules.
+func SetFallback(groups map[string]bool) error {
+	for g := range groups {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("Bad newsgroup pattern %q.", g)
+		}
+	}
+	rulesLock.Lock()
+	fallbackGroups = groups
+	installRules()
+	rulesLock.Unlock()
+	return nil
+}
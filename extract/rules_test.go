@@ -0,0 +1,149 @@
+package extract
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRuleFile = `{
+	"sets": [
+		{
+			"name": "test",
+			"groups": ["alt.binaries.test*"],
+			"rules": [
+				{
+					"regex": "^\\[(.+?)\\] ",
+					"index": 1
+				},
+				{
+					"regex": "^(?P<name>.+?) - ",
+					"capture": "name",
+					"groups": ["alt.binaries.test.only"]
+				}
+			]
+		}
+	]
+}`
+
+func TestParseRules(t *testing.T) {
+	rules, err := parseRules("rules.json", []byte(testRuleFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := newMatcherTable(rules)
+	if n := len(table.forGroup("alt.binaries.test.other")); n != 1 {
+		t.Errorf("alt.binaries.test.other has %d rules, want 1", n)
+	}
+	if n := len(table.forGroup("alt.binaries.test.only")); n != 2 {
+		t.Errorf("alt.binaries.test.only has %d rules, want 2", n)
+	}
+	if n := len(table.forGroup("alt.binaries.anime")); n != 0 {
+		t.Errorf("alt.binaries.anime has %d rules, want 0", n)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     string
+	}{
+		{`"index": 1`, `"index": 2`, "rules.json:7: "},
+		{`"index": 1`, `"index": 0`, "rules.json:7: "},
+		{`"capture": "name"`, `"capture": "nope"`, "rules.json:11: "},
+		{`"^\\[(.+?)\\] "`, `"^\\[(.+?\\] "`, "rules.json:7: "},
+		{`["alt.binaries.test.only"]`, `["alt.binaries.[test"]`, "rules.json:11: "},
+		{`"index": 1`, `"index": 1,`, "rules.json:10: "},
+		{`"capture": "name"`, `"capture": ""`, "rules.json:11: "},
+		{`"groups": ["alt.binaries.test*"]`, `"groups": []`, "rules.json:7: "},
+	}
+	for _, c := range cases {
+		data := strings.Replace(testRuleFile, c.from, c.to, 1)
+		_, err := parseRules("rules.json", []byte(data))
+		if err == nil {
+			t.Errorf("Replacing %s with %s: expected an error", c.from, c.to)
+		} else if !strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("Replacing %s with %s: got %q, want prefix %q", c.from, c.to, err.Error(), c.want)
+		}
+	}
+}
+
+func TestLoadOverlayFile(t *testing.T) {
+	dir := t.TempDir()
+	overlay := filepath.Join(dir, "overlay.json")
+	empty := filepath.Join(dir, "empty.json")
+	ioutil.WriteFile(empty, []byte(`{"sets": []}`), 0644)
+	defer LoadOverlayFile(empty)
+
+	subject := `[AST] One Piece - [007/340] - "One Piece episode 301-350.part006.rar" yEnc`
+	before := ExtractRelease("alt.binaries.anime", subject)
+
+	ioutil.WriteFile(overlay, []byte(strings.Replace(testRuleFile, "alt.binaries.test*", "alt.binaries.anime", 1)), 0644)
+	if err := LoadOverlayFile(overlay); err != nil {
+		t.Fatal(err)
+	}
+	if r := ExtractRelease("alt.binaries.anime", subject); r != "AST" {
+		t.Errorf("Overlay rule not tried first, got %q", r)
+	}
+
+	ioutil.WriteFile(overlay, []byte(`{"sets": [{"name": "bad", "groups": ["*"], "rules": [{"regex": "("}]}]}`), 0644)
+	if err := LoadOverlayFile(overlay); err == nil {
+		t.Errorf("Loaded an invalid overlay")
+	}
+	if r := ExtractRelease("alt.binaries.anime", subject); r != "AST" {
+		t.Errorf("Invalid overlay replaced the active rules, got %q", r)
+	}
+
+	LoadOverlayFile(empty)
+	if r := ExtractRelease("alt.binaries.anime", subject); r != before {
+		t.Errorf("Got %q after removing the overlay, want %q", r, before)
+	}
+}
+
+func TestRuleErrorWithoutFile(t *testing.T) {
+	_, err := compileRuleFile(RuleFile{Sets: []RuleSet{{Name: "bad", Groups: []string{"*"}, Rules: []Rule{{Regex: "x", Index: 1}}}}})
+	if err == nil || !strings.HasPrefix(err.Error(), "Invalid rule 0 in set 0. ") {
+		t.Errorf("Got %v, want the rule and set of the error", err)
+	}
+}
+
+func TestLoadRuleFile(t *testing.T) {
+	dir := t.TempDir()
+	rules := filepath.Join(dir, "rules.json")
+	defaults := filepath.Join(dir, "defaults.json")
+	data, err := json.Marshal(defaultRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(defaults, data, 0644)
+	defer func() {
+		if err := LoadRuleFile(defaults); err != nil {
+			t.Errorf("Failed to load the default rules back. (%s)", err)
+		}
+	}()
+
+	subject := `[AST] One Piece - [007/340] - "One Piece episode 301-350.part006.rar" yEnc`
+	ioutil.WriteFile(rules, []byte(strings.Replace(testRuleFile, "alt.binaries.test*", "alt.binaries.anime", 1)), 0644)
+	if err := LoadRuleFile(rules); err != nil {
+		t.Fatal(err)
+	}
+	if r := ExtractRelease("alt.binaries.anime", subject); r != "AST" {
+		t.Errorf("Rule file not used, got %q", r)
+	}
+	if n := len(Rules("alt.binaries.anime")); n != 1 {
+		t.Errorf("alt.binaries.anime has %d rules, want the 1 of the rule file", n)
+	}
+
+	ioutil.WriteFile(rules, []byte(strings.Replace(testRuleFile, `"index": 1`, `"index": 2`, 1)), 0644)
+	if err := LoadRuleFile(rules); err == nil {
+		t.Errorf("Loaded an invalid rule file")
+	}
+	if r := ExtractRelease("alt.binaries.anime", subject); r != "AST" {
+		t.Errorf("Invalid rule file replaced the active rules, got %q", r)
+	}
+	if err := LoadRuleFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("Loaded a missing rule file")
+	}
+}
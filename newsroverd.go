@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/animezb/newsrover"
+	"github.com/animezb/newsroverd/extract"
 	"github.com/animezb/newsroverd/sinks"
 	_ "github.com/animezb/newsroverd/sinks/elasticsink"
 	"io"
@@ -31,10 +32,18 @@ type SinkConf struct {
 	Options json.RawMessage `json:"options"`
 }
 
+type ExtractConf struct {
+	Rules             string          `json:"rules"`
+	Overlay           string          `json:"overlay"`
+	OverlayCheckEvery int             `json:"overlay_check_every"`
+	Fallback          map[string]bool `json:"fallback"`
+}
+
 type RoverDConf struct {
 	LogFile string                  `json:"log"`
 	Rovers  []newsrover.RoverConfig `json:"newsgroups"`
 	Sinks   []SinkConf              `json:"sinks"`
+	Extract ExtractConf             `json:"extract"`
 }
 
 func ctrlc(stop chan<- bool) {
@@ -58,17 +67,44 @@ func ctrlc(stop chan<- bool) {
 	}()
 }
 
-func main() {
-	flag.Parse()
-	runtime.GOMAXPROCS(runtime.NumCPU())
-
+// reloadOverlay reloads the extraction overlay rule file on SIGHUP, or when
+// its modification time changes. A bad rule file is logged and the rules
+// already in use are kept.
+func reloadOverlay(overlay string, checkEvery time.Duration, logger *log.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var modTime time.Time
+	if fi, err := os.Stat(overlay); err == nil {
+		modTime = fi.ModTime()
+	}
+	check := time.NewTicker(checkEvery)
 	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
+		for {
+			select {
+			case <-hup:
+				if fi, err := os.Stat(overlay); err == nil {
+					modTime = fi.ModTime()
+				}
+			case <-check.C:
+				fi, err := os.Stat(overlay)
+				if err != nil || fi.ModTime().Equal(modTime) {
+					continue
+				}
+				modTime = fi.ModTime()
+			}
+			if err := extract.LoadOverlayFile(overlay); err != nil {
+				logger.Printf("Failed to reload extraction overlay, keeping previous rules. %s", err.Error())
+			} else {
+				logger.Printf("Reloaded extraction overlay %s.", overlay)
+			}
+		}
 	}()
+}
 
+// loadConfig reads the configuration file and loads the extraction rules it
+// references. It exits the process on failure.
+func loadConfig() RoverDConf {
 	var conf RoverDConf
-	var logStream io.Writer
-
 	if config, err := ioutil.ReadFile(configFile); err != nil {
 		fmt.Printf("Error: Failed to open configuration file %s. (%s)\n", configFile, err.Error())
 		os.Exit(1)
@@ -79,6 +115,50 @@ func main() {
 		}
 	}
 
+	if conf.Extract.Fallback != nil {
+		if err := extract.SetFallback(conf.Extract.Fallback); err != nil {
+			fmt.Printf("Error: Failed to configure fallback extraction. %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	if conf.Extract.Rules != "" {
+		if err := extract.LoadRuleFile(conf.Extract.Rules); err != nil {
+			fmt.Printf("Error: Failed to load extraction rules. %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	if conf.Extract.Overlay != "" {
+		if err := extract.LoadOverlayFile(conf.Extract.Overlay); err != nil {
+			fmt.Printf("Error: Failed to load extraction overlay. %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	return conf
+}
+
+func main() {
+	flag.Parse()
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "extract-report":
+			os.Exit(extractReport(flag.Args()[1:]))
+		case "replay":
+			os.Exit(replay(flag.Args()[1:]))
+		default:
+			fmt.Printf("Error: Unknown command %s.\n", flag.Arg(0))
+			os.Exit(1)
+		}
+	}
+
+	go func() {
+		log.Println(http.ListenAndServe("localhost:6060", nil))
+	}()
+
+	var logStream io.Writer
+	conf := loadConfig()
+
 	if conf.LogFile != "" {
 		logfile, err := os.OpenFile(conf.LogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 		if err == nil {
@@ -123,6 +203,14 @@ func main() {
 
 	generalLog.Printf("Loaded %d sinks.", len(newsSinks))
 
+	if conf.Extract.Overlay != "" {
+		checkEvery := 10 * time.Second
+		if conf.Extract.OverlayCheckEvery > 0 {
+			checkEvery = time.Duration(conf.Extract.OverlayCheckEvery) * time.Second
+		}
+		reloadOverlay(conf.Extract.Overlay, checkEvery, generalLog)
+	}
+
 	rovers := make([]*newsrover.Rover, 0, 4)
 	for _, c := range conf.Rovers {
 		if c.SSL {
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/animezb/goes"
+	"github.com/animezb/newsrover"
+	"github.com/animezb/newsroverd/sinks"
+	"github.com/animezb/newsroverd/sinks/elasticsink"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// replaySink is implemented by sinks that can replay their fail log.
+type replaySink interface {
+	newsrover.Sink
+	Serving() bool
+	// Err returns why Serve returned without serving, if it did.
+	Err() error
+	ReplayDocuments(docs []goes.Document) int
+}
+
+type failLog struct {
+	name     string
+	size     int64
+	articles []newsrover.Article
+	docs     []goes.Document
+	kept     [][]byte
+}
+
+// readFailLog reads the articles and documents of a fail log. Lines that are
+// neither are kept aside, so they survive a replay with -delete.
+func readFailLog(name string) (*failLog, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fl := &failLog{name: name}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		fl.size += int64(len(scanner.Bytes())) + 1
+		if len(line) == 0 {
+			continue
+		}
+		if doc, ok := elasticsink.ParseFailedDocument(line); ok {
+			fl.docs = append(fl.docs, doc)
+			continue
+		}
+		var a newsrover.Article
+		if err := json.Unmarshal(line, &a); err == nil && a.Subject != "" && a.MessageId != "" {
+			fl.articles = append(fl.articles, a)
+			continue
+		}
+		fl.kept = append(fl.kept, append([]byte(nil), line...))
+	}
+	return fl, scanner.Err()
+}
+
+// truncate removes the replayed lines from the fail log. Lines that were
+// kept, and lines the sinks appended since the log was read, remain.
+func (fl *failLog) truncate() error {
+	f, err := os.Open(fl.name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(fl.size, 0); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fl.name), ".replay")
+	if err != nil {
+		return err
+	}
+	for _, line := range fl.kept {
+		tmp.Write(line)
+		tmp.Write([]byte{'\n'})
+	}
+	if _, err := io.Copy(tmp, f); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fl.name)
+}
+
+type replayProgress struct {
+	total, articles, docs, failed int
+	every                         time.Duration
+	last                          time.Time
+}
+
+func (p *replayProgress) report(force bool) {
+	if !force && time.Since(p.last) < p.every {
+		return
+	}
+	p.last = time.Now()
+	fmt.Printf("Replayed %d of %d records (%d articles, %d documents, %d documents failed again).\n", p.articles+p.docs, p.total, p.articles, p.docs, p.failed)
+}
+
+// replay implements the replay command. It feeds the articles and documents
+// of sink fail logs back through a configured sink.
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	sinkName := fs.String("sink", "elasticsearch", "Name of the configured sink to replay through.")
+	batch := fs.Int("batch", 512, "Number of articles or documents sent to the sink at once.")
+	remove := fs.Bool("delete", false, "Delete replayed lines from the fail logs.")
+	every := fs.Duration("progress", 5*time.Second, "Interval between progress reports.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: newsroverd [-config file] replay [options] file ...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || *batch <= 0 {
+		fs.Usage()
+		return 1
+	}
+
+	conf := loadConfig()
+	var sinkConf *SinkConf
+	for i := range conf.Sinks {
+		if conf.Sinks[i].Name == *sinkName {
+			sinkConf = &conf.Sinks[i]
+		}
+	}
+	if sinkConf == nil {
+		fmt.Printf("Error: Sink %s is not configured.\n", *sinkName)
+		return 1
+	}
+
+	logs := make([]*failLog, 0, fs.NArg())
+	progress := &replayProgress{every: *every}
+	for _, name := range fs.Args() {
+		fl, err := readFailLog(name)
+		if err != nil {
+			fmt.Printf("Error: Failed to read fail log %s. (%s)\n", name, err.Error())
+			return 1
+		}
+		progress.total += len(fl.articles) + len(fl.docs)
+		logs = append(logs, fl)
+	}
+
+	options, err := replayOptions(sinkConf.Options)
+	if err != nil {
+		fmt.Printf("Error: Failed to read the options of sink %s. (%s)\n", sinkConf.Name, err.Error())
+		return 1
+	}
+	s, err := sinks.CreateSink(sinkConf.Name, options)
+	if err != nil {
+		fmt.Printf("Error: Failed to initiate sink %s. (%s)\n", sinkConf.Name, err.Error())
+		return 1
+	}
+	replayer, ok := s.(replaySink)
+	if !ok {
+		fmt.Printf("Error: Sink %s does not support replay.\n", sinkConf.Name)
+		return 1
+	}
+	replayer.SetLogger(log.New(os.Stdout, "", log.LstdFlags))
+	served := make(chan bool)
+	go func() {
+		replayer.Serve()
+		close(served)
+	}()
+	wait := time.NewTicker(10 * time.Millisecond)
+	for !replayer.Serving() {
+		select {
+		case <-served:
+			wait.Stop()
+			fmt.Printf("Error: Sink %s stopped before serving. (%v)\n", sinkConf.Name, replayer.Err())
+			return 1
+		case <-wait.C:
+		}
+	}
+	wait.Stop()
+
+	for _, fl := range logs {
+		for i := 0; i < len(fl.docs); i += *batch {
+			end := i + *batch
+			if end > len(fl.docs) {
+				end = len(fl.docs)
+			}
+			progress.failed += replayer.ReplayDocuments(fl.docs[i:end])
+			progress.docs += end - i
+			progress.report(false)
+		}
+		for i := 0; i < len(fl.articles); i += *batch {
+			end := i + *batch
+			if end > len(fl.articles) {
+				end = len(fl.articles)
+			}
+			replayer.Accept(fl.articles[i:end])
+			progress.articles += end - i
+			progress.report(false)
+		}
+	}
+	replayer.Stop()
+	<-served
+	progress.report(true)
+
+	if *remove {
+		for _, fl := range logs {
+			if err := fl.truncate(); err != nil {
+				fmt.Printf("Error: Failed to delete replayed lines from %s. (%s)\n", fl.name, err.Error())
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// replayOptions sets replay in the options of a sink, so it leaves what the
+// daemon owns, like its write ahead log and spill file, alone.
+func replayOptions(options json.RawMessage) (json.RawMessage, error) {
+	o := make(map[string]json.RawMessage)
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &o); err != nil {
+			return nil, err
+		}
+	}
+	if o == nil {
+		o = make(map[string]json.RawMessage)
+	}
+	o["replay"] = json.RawMessage("true")
+	return json.Marshal(o)
+}
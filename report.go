@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/animezb/newsrover"
+	"github.com/animezb/newsroverd/extract"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var shapeWords = regexp.MustCompile(`w(?:[ ._]+w)+`)
+
+type subjectShape struct {
+	shape   string
+	count   int
+	example string
+}
+
+type groupReport struct {
+	group     string
+	total     int
+	matched   int
+	files     int
+	segmented int
+	hits      map[extract.RuleRef]int
+	shapes    map[string]*subjectShape
+}
+
+// shapeOf normalizes a subject into a pattern so similar subjects can be
+// clustered. Words become "w", numbers become "9", runs of words separated
+// by spaces, dots or underscores collapse into one "w".
+func shapeOf(subject string) string {
+	var shape []byte
+	for i := 0; i < len(subject); {
+		c := subject[i]
+		if !isWordChar(c) {
+			if c != ' ' || len(shape) == 0 || shape[len(shape)-1] != ' ' {
+				shape = append(shape, c)
+			}
+			i++
+			continue
+		}
+		j, digits := i, true
+		for ; j < len(subject) && isWordChar(subject[j]); j++ {
+			if subject[j] < '0' || subject[j] > '9' {
+				digits = false
+			}
+		}
+		switch {
+		case strings.EqualFold(subject[i:j], "yenc"):
+			shape = append(shape, "yEnc"...)
+		case digits:
+			shape = append(shape, '9')
+		default:
+			shape = append(shape, 'w')
+		}
+		i = j
+	}
+	return shapeWords.ReplaceAllString(string(shape), "w")
+}
+
+func isWordChar(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func (r *groupReport) add(subject string) {
+	r.total++
+	if _, rule, ok := extract.MatchRelease(r.group, subject); ok {
+		r.matched++
+		r.hits[rule]++
+	} else {
+		shape := shapeOf(subject)
+		if s, ok := r.shapes[shape]; ok {
+			s.count++
+		} else {
+			r.shapes[shape] = &subjectShape{shape: shape, count: 1, example: subject}
+		}
+	}
+	if extract.ExtractFile(subject) != "" {
+		r.files++
+	}
+	if extract.ExtractYencLength(subject) > 1 {
+		r.segmented++
+	}
+}
+
+func (r *groupReport) print(w io.Writer, top int) {
+	unmatched := r.total - r.matched
+	fmt.Fprintf(w, "%s: %d subjects, %d matched, %d unmatched (%.2f%%)\n", r.group, r.total, r.matched, unmatched, 100*float64(unmatched)/float64(r.total))
+	fmt.Fprintf(w, "  filename found in %d, yEnc part found in %d\n", r.files, r.segmented)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "  rule\thits\n")
+	for _, rule := range extract.Rules(r.group) {
+		fmt.Fprintf(tw, "  %s\t%d\n", rule, r.hits[rule])
+	}
+	tw.Flush()
+
+	if unmatched == 0 {
+		fmt.Fprintln(w)
+		return
+	}
+	shapes := make([]*subjectShape, 0, len(r.shapes))
+	for _, s := range r.shapes {
+		shapes = append(shapes, s)
+	}
+	sort.Slice(shapes, func(i, j int) bool {
+		if shapes[i].count == shapes[j].count {
+			return shapes[i].shape < shapes[j].shape
+		}
+		return shapes[i].count > shapes[j].count
+	})
+	if len(shapes) > top {
+		shapes = shapes[:top]
+	}
+	fmt.Fprintf(w, "  top unmatched subjects:\n")
+	for _, s := range shapes {
+		fmt.Fprintf(w, "  %6d  %s\n          e.g. %s\n", s.count, s.shape, s.example)
+	}
+	fmt.Fprintln(w)
+}
+
+// extractReport implements the extract-report command. It reads subjects,
+// one per line, or articles from a sink fail log and reports how well the
+// extraction rules cover them.
+func extractReport(args []string) int {
+	fs := flag.NewFlagSet("extract-report", flag.ExitOnError)
+	group := fs.String("group", "", "Newsgroup of plain subject lines, overrides the group of fail log articles.")
+	top := fs.Int("top", 20, "Number of unmatched subject shapes to show per group.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: newsroverd [-config file] extract-report [options] [file ...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if _, err := os.Stat(configFile); err == nil {
+		loadConfig()
+	}
+
+	inputs := make([]io.Reader, 0, fs.NArg())
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Printf("Error: Failed to open %s. (%s)\n", name, err.Error())
+			return 1
+		}
+		defer f.Close()
+		inputs = append(inputs, f)
+	}
+	if len(inputs) == 0 {
+		inputs = append(inputs, os.Stdin)
+	}
+
+	if err := report(os.Stdout, io.MultiReader(inputs...), *group, *top); err != nil {
+		fmt.Printf("Error: Failed to read subjects. (%s)\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// report reads subjects and fail log articles from in and writes the report
+// of their groups to w. Subjects are in group, or the group of their article
+// if it is empty.
+func report(w io.Writer, in io.Reader, group string, top int) error {
+	reports := make(map[string]*groupReport)
+	skipped, documents := 0, 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		subject, g := line, group
+		// Lines that aren't JSON are subjects starting with a brace.
+		var a newsrover.Article
+		if line[0] == '{' && json.Unmarshal([]byte(line), &a) == nil {
+			if a.Subject == "" {
+				// Not an article, ie. a document from a failed bulk flush.
+				documents++
+				continue
+			}
+			subject = a.Subject
+			if g == "" {
+				g = a.Group
+			}
+		}
+		if g == "" {
+			skipped++
+			continue
+		}
+		r, ok := reports[g]
+		if !ok {
+			r = &groupReport{
+				group:  g,
+				hits:   make(map[extract.RuleRef]int),
+				shapes: make(map[string]*subjectShape),
+			}
+			reports[g] = r
+		}
+		r.add(subject)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	groups := make([]string, 0, len(reports))
+	for g := range reports {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		reports[g].print(w, top)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(w, "Skipped %d subjects without a newsgroup.\n", skipped)
+	}
+	if documents > 0 {
+		fmt.Fprintf(w, "Skipped %d JSON documents that aren't articles.\n", documents)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestShapeOf(t *testing.T) {
+	for _, c := range []struct {
+		subject, shape string
+	}{
+		{`[AST] One Piece - [007/340] - "One Piece episode 301-350.part006.rar" yEnc (1/10)`, `[w] w - [9/9] - "w 9-9.w" yEnc (9/9)`},
+		{`Some.Show.S01E05.720p.HDTV.x264-GRP`, `w-w`},
+		{`abc_def  123   YENC`, `w 9 yEnc`},
+		{`{Group} Title`, `{w} w`},
+		{`Café du Monde 2`, `w 9`},
+		{``, ``},
+	} {
+		if shape := shapeOf(c.subject); shape != c.shape {
+			t.Errorf("shapeOf(%q) = %q, want %q", c.subject, shape, c.shape)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	in := strings.Join([]string{
+		`{"Group": "alt.binaries.anime", "Subject": "[AST] One Piece - [007/340] - \"One Piece episode 301-350.part006.rar\" yEnc (1/10)"}`,
+		`{"Group": "alt.binaries.anime", "Subject": "nothing to see here (1/2)"}`,
+		`{Group} Title yEnc`,
+		`{"_id": "u", "subject": ""}`,
+		``,
+	}, "\n")
+	var out bytes.Buffer
+	if err := report(&out, strings.NewReader(in), "", 5); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"alt.binaries.anime: 2 subjects, 1 matched, 1 unmatched (50.00%)",
+		"w (9/9)",
+		"Skipped 1 subjects without a newsgroup.",
+		"Skipped 1 JSON documents that aren't articles.",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Report has no %q:\n%s", want, out.String())
+		}
+	}
+
+	// Subjects starting with a brace are reported in the given group.
+	out.Reset()
+	report(&out, strings.NewReader("{Group} Title yEnc\n"), "alt.binaries.test", 5)
+	if !strings.Contains(out.String(), "alt.binaries.test: 1 subjects") || !strings.Contains(out.String(), "{w} w yEnc") {
+		t.Errorf("Subject starting with a brace not reported:\n%s", out.String())
+	}
+}
@@ -0,0 +1,317 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/animezb/goes"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const maxRetryBackoff = time.Minute
+
+// DeadLetter is a document ElasticSearch refused, or that could not be sent
+// within the configured retries. Dead letters are written one per line to
+// the dead letter queue.
+type DeadLetter struct {
+	Time     time.Time `json:"time"`
+	Error    string    `json:"error"`
+	Status   int       `json:"status,omitempty"`
+	Attempts int       `json:"attempts"`
+
+	Index       interface{} `json:"index"`
+	Type        string      `json:"type"`
+	Id          interface{} `json:"id"`
+	Parent      interface{} `json:"parent,omitempty"`
+	BulkCommand string      `json:"command"`
+	Fields      interface{} `json:"fields"`
+}
+
+// Document returns the document to send to ElasticSearch again.
+func (d DeadLetter) Document() goes.Document {
+	return goes.Document{
+		Index:       d.Index,
+		Type:        d.Type,
+		Id:          d.Id,
+		Parent:      d.Parent,
+		BulkCommand: d.BulkCommand,
+		Fields:      d.Fields,
+	}
+}
+
+// bulkItem is the result of a single document of a bulk request.
+type bulkItem struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func (b bulkItem) failed() bool {
+	return b.Status >= 300 || (len(b.Error) > 0 && string(b.Error) != "null")
+}
+
+// message returns the error of the item. ElasticSearch 1.x reports errors as
+// strings, later versions as an object with a type and a reason.
+func (b bulkItem) message() string {
+	var s string
+	if err := json.Unmarshal(b.Error, &s); err == nil {
+		return s
+	}
+	var e struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(b.Error, &e); err == nil && e.Type != "" {
+		return fmt.Sprintf("%s[%s]", e.Type, e.Reason)
+	}
+	return string(b.Error)
+}
+
+// errorType returns the type of the error of the item, the exception name on
+// ElasticSearch 1.x.
+func (b bulkItem) errorType() string {
+	var e struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b.Error, &e); err == nil && e.Type != "" {
+		return e.Type
+	}
+	msg := b.message()
+	if i := strings.IndexAny(msg, "[ :"); i > 0 {
+		msg = msg[:i]
+	}
+	if msg == "" || msg == "null" {
+		return strconv.Itoa(b.Status)
+	}
+	return msg
+}
+
+// retryable reports whether sending the document again may succeed: the
+// cluster was too busy, an update raced another one, or an update arrived
+// before the document it updates was created.
+func (b bulkItem) retryable(command string) bool {
+	switch b.Status {
+	case 429, 503:
+		return true
+	case 404, 409:
+		return command == "update"
+	}
+	msg := b.message()
+	for _, e := range []string{"EsRejectedExecutionException", "es_rejected_execution_exception", "VersionConflictEngineException", "version_conflict_engine_exception", "DocumentMissingException", "document_missing_exception"} {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// exists reports whether the item failed because it was created before,
+// which is expected of reposted segments.
+func (b bulkItem) exists(command string) bool {
+	return command == "create" && b.Status == 409
+}
+
+func parseBulkItems(items []byte) ([]bulkItem, error) {
+	var raw []map[string]bulkItem
+	if err := json.Unmarshal(items, &raw); err != nil {
+		return nil, err
+	}
+	parsed := make([]bulkItem, len(raw))
+	for i, item := range raw {
+		for _, v := range item {
+			parsed[i] = v
+		}
+	}
+	return parsed, nil
+}
+
+// bulkFailures splits the documents of a bulk request that failed into the
+// ones to send again and the ones to give up on.
+func bulkFailures(docs []goes.Document, items []bulkItem) (retry []goes.Document, failed []DeadLetter) {
+	for i, item := range items {
+		if i >= len(docs) || !item.failed() || item.exists(docs[i].BulkCommand) {
+			continue
+		}
+		if item.retryable(docs[i].BulkCommand) {
+			retry = append(retry, docs[i])
+		} else {
+			failed = append(failed, newDeadLetter(docs[i], item.Status, item.message()))
+		}
+	}
+	return retry, failed
+}
+
+func newDeadLetter(doc goes.Document, status int, err string) DeadLetter {
+	return DeadLetter{
+		Time:        time.Now(),
+		Error:       err,
+		Status:      status,
+		Index:       doc.Index,
+		Type:        doc.Type,
+		Id:          doc.Id,
+		Parent:      doc.Parent,
+		BulkCommand: doc.BulkCommand,
+		Fields:      doc.Fields,
+	}
+}
+
+// bulkActionSize is about the size of the action line of a document in a
+// bulk request.
+const bulkActionSize = 128
+
+// docSize returns about the size of a document in a bulk request.
+func docSize(doc goes.Document) int {
+	if raw, ok := doc.Fields.(json.RawMessage); ok {
+		return len(raw) + bulkActionSize
+	}
+	data, _ := json.Marshal(doc.Fields)
+	return len(data) + bulkActionSize
+}
+
+// uploadDocSize returns about the size of the document of an upload buffered
+// for a flush, which is written whole, from its state when it has one.
+func (es *ElasticSink) uploadDocSize(u Upload) int {
+	st, _ := es.cachedState(u.Id)
+	if st == nil {
+		return docSize(goes.Document{Fields: u})
+	}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return docSize(goes.Document{Fields: st.upload})
+}
+
+// fileDocSize returns about the size of the document of a file buffered for a
+// flush, along with the segments it lists in compaction mode. A file new to
+// its upload is also added to the progress of the upload document.
+func (es *ElasticSink) fileDocSize(f File) int {
+	st, _ := es.cachedState(f.ParentId)
+	if st == nil {
+		return 2 * docSize(goes.Document{Fields: f})
+	}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	fs, ok := st.files[f.Id]
+	if !ok {
+		return 2 * docSize(goes.Document{Fields: f})
+	}
+	n := docSize(goes.Document{Fields: st.file(fs)})
+	if es.compact && fs.Length <= es.compactMax {
+		for _, s := range fs.segments {
+			n += compactSegmentSize + len(s.MessageId)
+		}
+	}
+	return n
+}
+
+// bulkBatches splits docs, in order, into batches of at most maxBytes. A
+// document larger than maxBytes is sent alone. A maxBytes of 0 sends every
+// document at once.
+func bulkBatches(docs []goes.Document, maxBytes int) [][]goes.Document {
+	if maxBytes <= 0 {
+		return [][]goes.Document{docs}
+	}
+	var batches [][]goes.Document
+	start, size := 0, 0
+	for i, doc := range docs {
+		n := docSize(doc)
+		if i > start && size+n > maxBytes {
+			batches = append(batches, docs[start:i])
+			start, size = i, 0
+		}
+		size += n
+	}
+	return append(batches, docs[start:])
+}
+
+// bulkSend sends docs to ElasticSearch, in bulk requests of at most
+// flushBytes. Documents that can't be indexed are written to the dead letter
+// queue, and returned.
+func (es *ElasticSink) bulkSend(docs []goes.Document) []goes.Document {
+	var dead []goes.Document
+	for _, batch := range bulkBatches(docs, es.flushBytes) {
+		dead = append(dead, es.bulkSendBatch(batch)...)
+	}
+	return dead
+}
+
+// bulkSendBatch sends docs in a bulk request, retrying the request and then
+// the documents that failed with a retryable error, with an exponential
+// backoff.
+func (es *ElasticSink) bulkSendBatch(docs []goes.Document) []goes.Document {
+	backoff := es.retryBackoff
+	var dead []goes.Document
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		r, err := es.client.BulkSend(docs)
+		bulkSeconds.WithLabelValues(es.instance).Observe(time.Since(start).Seconds())
+		if err != nil {
+			if attempt > es.retries {
+				es.logger.Printf("Error: Failed to bulk flush %d documents after %d attempts. (%s)", len(docs), attempt, err.Error())
+				es.deadLetterAll(docs, attempt, err.Error())
+				return append(dead, docs...)
+			}
+			es.logger.Printf("Error: Failed to bulk flush %d documents, retrying in %s. (%s)", len(docs), backoff, err.Error())
+		} else {
+			es.logger.Printf("Flushed %d documents took %dms. (%d)", len(docs), r.Took, atomic.LoadInt64(&es.processed))
+			if !r.Errors {
+				es.countBulk(docs, nil)
+				return dead
+			}
+			items, err := parseBulkItems(r.Items)
+			if err != nil {
+				es.logger.Printf("Error: Failed to parse the bulk response of %d documents. (%s)", len(docs), err.Error())
+				es.deadLetterAll(docs, attempt, string(r.Items))
+				return append(dead, docs...)
+			}
+			es.countBulk(docs, items)
+			retry, failed := bulkFailures(docs, items)
+			for i := range failed {
+				failed[i].Attempts = attempt
+				dead = append(dead, failed[i].Document())
+			}
+			es.writeDeadLetters(failed)
+			if len(retry) == 0 {
+				return dead
+			}
+			if attempt > es.retries {
+				es.logger.Printf("Error: Failed to index %d documents after %d attempts.", len(retry), attempt)
+				es.deadLetterAll(retry, attempt, "Retries exhausted.")
+				return append(dead, retry...)
+			}
+			es.logger.Printf("%d documents failed, %d will be retried in %s.", len(failed)+len(retry), len(retry), backoff)
+			docs = retry
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+func (es *ElasticSink) deadLetterAll(docs []goes.Document, attempts int, err string) {
+	letters := make([]DeadLetter, len(docs))
+	for i, doc := range docs {
+		letters[i] = newDeadLetter(doc, 0, err)
+		letters[i].Attempts = attempts
+	}
+	es.writeDeadLetters(letters)
+}
+
+func (es *ElasticSink) writeDeadLetters(letters []DeadLetter) {
+	if len(letters) == 0 {
+		return
+	}
+	es.failLogLock.Lock()
+	defer es.failLogLock.Unlock()
+	enc := json.NewEncoder(es.deadLetters)
+	for _, l := range letters {
+		if err := enc.Encode(l); err != nil {
+			es.logger.Printf("Error: Failed to write %s %v to the dead letter queue. (%s)", l.Type, l.Id, err.Error())
+		}
+	}
+	if f, ok := es.deadLetters.(*os.File); ok {
+		f.Sync()
+	}
+}
@@ -0,0 +1,110 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/animezb/goes"
+	"github.com/golang/groupcache/lru"
+	"strings"
+	"testing"
+)
+
+func TestBulkFailures(t *testing.T) {
+	docs := []goes.Document{
+		{Type: "upload", Id: "a", BulkCommand: "create"},
+		{Type: "upload", Id: "a", BulkCommand: "update"},
+		{Type: "file", Id: "b", BulkCommand: "update"},
+		{Type: "segment", Id: "c", BulkCommand: "create"},
+		{Type: "segment", Id: "d", BulkCommand: "create"},
+		{Type: "segment", Id: "e", BulkCommand: "create"},
+	}
+	items, err := parseBulkItems([]byte(`[
+		{"create": {"_id": "a", "status": 409, "error": "DocumentAlreadyExistsException[[nzb][0] [upload][a]: document already exists]"}},
+		{"update": {"_id": "a", "status": 409, "error": "VersionConflictEngineException[[nzb][0] [upload][a]: version conflict, current [2], provided [1]]"}},
+		{"update": {"_id": "b", "status": 404, "error": {"type": "document_missing_exception", "reason": "[file][b]: document missing"}}},
+		{"create": {"_id": "c", "status": 201}},
+		{"create": {"_id": "d", "status": 429, "error": "EsRejectedExecutionException[rejected execution (queue capacity 50)]"}},
+		{"create": {"_id": "e", "status": 400, "error": "MapperParsingException[failed to parse [date]]"}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry, failed := bulkFailures(docs, items)
+	var ids []interface{}
+	for _, d := range retry {
+		ids = append(ids, d.Id)
+	}
+	if len(retry) != 3 || retry[0].Id != "a" || retry[1].Id != "b" || retry[2].Id != "d" {
+		t.Errorf("Retrying %v, want [a b d]", ids)
+	}
+	if len(failed) != 1 || failed[0].Id != "e" || failed[0].Status != 400 {
+		t.Errorf("Dead letters %+v, want e", failed)
+	}
+	if failed[0].Error != "MapperParsingException[failed to parse [date]]" {
+		t.Errorf("Dead letter error %q", failed[0].Error)
+	}
+}
+
+func TestBulkItemErrorType(t *testing.T) {
+	items, _ := parseBulkItems([]byte(`[
+		{"create": {"status": 429, "error": "EsRejectedExecutionException[rejected execution (queue capacity 50)]"}},
+		{"update": {"status": 404, "error": {"type": "document_missing_exception", "reason": "[file][b]: document missing"}}},
+		{"index": {"status": 500}}
+	]`))
+	for i, want := range []string{"EsRejectedExecutionException", "document_missing_exception", "500"} {
+		if got := items[i].errorType(); got != want {
+			t.Errorf("Item %d error type %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestBulkBatches(t *testing.T) {
+	doc := func(id string, size int) goes.Document {
+		fields := json.RawMessage(`"` + strings.Repeat("x", size-bulkActionSize-2) + `"`)
+		return goes.Document{Id: id, Fields: fields}
+	}
+	docs := []goes.Document{doc("a", 400), doc("b", 400), doc("c", 1500), doc("d", 400), doc("e", 400), doc("f", 400)}
+	batches := bulkBatches(docs, 1000)
+	var got []string
+	for _, b := range batches {
+		var ids []string
+		for _, d := range b {
+			ids = append(ids, d.Id.(string))
+		}
+		got = append(got, strings.Join(ids, ""))
+	}
+	if strings.Join(got, " ") != "ab c de f" {
+		t.Errorf("Batches %v, want [ab c de f]", got)
+	}
+	if batches := bulkBatches(docs, 0); len(batches) != 1 || len(batches[0]) != len(docs) {
+		t.Errorf("Split without a maximum size")
+	}
+}
+
+func TestBufferedDocSize(t *testing.T) {
+	es := &ElasticSink{states: lru.New(8)}
+	file := File{Id: "f1", ParentId: "u", Filename: "a.rar", Length: 2, Segments: []*Segment{{Part: 1}}}
+	fileSize := docSize(goes.Document{Fields: file})
+
+	// Without a state, documents are written from the buffered ones, and a
+	// new file is added to the upload document.
+	if n := es.uploadDocSize(Upload{Id: "u"}); n != docSize(goes.Document{Fields: Upload{Id: "u"}}) {
+		t.Errorf("Upload without a state is %d bytes", n)
+	}
+	if n := es.fileDocSize(file); n != 2*fileSize {
+		t.Errorf("New file is %d bytes, want %d", n, 2*fileSize)
+	}
+
+	// With a state, the upload is written whole.
+	st := newUploadState("nzb", Upload{Id: "u"})
+	for i := 0; i < 20; i++ {
+		st.mergeFile(File{Id: fmt.Sprintf("f%d", i), ParentId: "u", Filename: "a.rar", Length: 2})
+	}
+	es.states.Add("u", st)
+	if n, min := es.uploadDocSize(Upload{Id: "u"}), 20*len(`{"id":"f1"}`); n < min {
+		t.Errorf("Upload of 20 files is %d bytes, want at least %d", n, min)
+	}
+	if n, want := es.fileDocSize(file), docSize(goes.Document{Fields: st.file(st.files["f1"])}); n != want {
+		t.Errorf("Known file is %d bytes, want %d", n, want)
+	}
+}
@@ -0,0 +1,179 @@
+package elasticsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/animezb/goes"
+	"time"
+)
+
+// bulkResponse is the response of a bulk request, Items holds the JSON
+// array of the results of every document.
+type bulkResponse struct {
+	Took   uint64
+	Errors bool
+	Items  []byte
+}
+
+// bulkClient sends the documents built by the sink to a cluster. Documents
+// are built for the ElasticSearch 1.x layout (index types and _parent),
+// clients for other clusters translate them.
+type bulkClient interface {
+	// Setup prepares the cluster for the documents of the sink, it is
+	// called when the sink starts.
+	Setup() error
+	// BulkSend sends documents, the response has an item for every
+	// document, in order.
+	BulkSend(docs []goes.Document) (bulkResponse, error)
+	// Get returns the sources of the documents of a type found in an
+	// index, by id. parents holds the parent of child documents by id.
+	Get(index, docType string, ids []string, parents map[string]string) (map[string]json.RawMessage, error)
+	// Unchecked returns up to size uploads posted before a date and not
+	// taken down, the ones checked for takedowns the longest ago first. The
+	// index of a hit is the index of the sink the upload is in.
+	Unchecked(before time.Time, size int) ([]searchHit, error)
+	// Find returns the uploads found by id in any index of the sink, through
+	// the search alias of time based indices. The index of a hit is the
+	// index of the sink the upload is in.
+	Find(ids []string) ([]searchHit, error)
+}
+
+// legacyClient sends documents to ElasticSearch 1.x.
+type legacyClient struct {
+	http      *httpClient
+	index     indexTemplate
+	alias     string
+	bootstrap string
+}
+
+func newLegacyClient(http *httpClient, index indexTemplate, alias string, bootstrap string) *legacyClient {
+	return &legacyClient{
+		http:      http,
+		index:     index,
+		alias:     alias,
+		bootstrap: bootstrap,
+	}
+}
+
+// Setup creates or verifies the index and its mappings, if bootstrap is set.
+// Time based indices are created by ElasticSearch from an index template.
+func (c *legacyClient) Setup() error {
+	if c.bootstrap == "" {
+		return nil
+	}
+	if c.index.timeBased() {
+		return bootstrapLegacyTemplate(c.http, c.alias, c.index.pattern(), c.alias, c.bootstrap == bootstrapCreate)
+	}
+	return bootstrapLegacy(c.http, c.index.name(time.Time{}), c.bootstrap == bootstrapCreate)
+}
+
+type legacyAction struct {
+	Index  interface{} `json:"_index"`
+	Type   string      `json:"_type"`
+	Id     interface{} `json:"_id,omitempty"`
+	Parent interface{} `json:"_parent,omitempty"`
+}
+
+// BulkSend sends documents to the index they name.
+func (c *legacyClient) BulkSend(docs []goes.Document) (bulkResponse, error) {
+	return sendBulk(c.http, docs, func(doc goes.Document) ([]byte, []byte, error) {
+		index := doc.Index
+		if index == nil || index == "" {
+			return nil, nil, fmt.Errorf("No index to write it to.")
+		}
+		source, err := json.Marshal(doc.Fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		action, err := json.Marshal(map[string]legacyAction{doc.BulkCommand: {
+			Index:  index,
+			Type:   doc.Type,
+			Id:     doc.Id,
+			Parent: doc.Parent,
+		}})
+		return action, source, err
+	})
+}
+
+// Get routes child documents to the shard of their parent.
+func (c *legacyClient) Get(index, docType string, ids []string, parents map[string]string) (map[string]json.RawMessage, error) {
+	return c.http.mget("/"+index+"/"+docType+"/_mget", ids, parents)
+}
+
+// searchIndex returns the index to search the documents of the sink in.
+func searchIndex(index indexTemplate, alias string) string {
+	if index.timeBased() {
+		return alias
+	}
+	return index.name(time.Time{})
+}
+
+func (c *legacyClient) Unchecked(before time.Time, size int) ([]searchHit, error) {
+	return c.http.search("/"+searchIndex(c.index, c.alias)+"/upload/_search", map[string]interface{}{
+		"size": size,
+		"query": map[string]interface{}{
+			"filtered": map[string]interface{}{
+				"filter": map[string]interface{}{
+					"bool": map[string]interface{}{
+						"must": []interface{}{
+							map[string]interface{}{"term": map[string]interface{}{"dmca": false}},
+							map[string]interface{}{"range": map[string]interface{}{"date": map[string]interface{}{"lt": before}}},
+						},
+					},
+				},
+			},
+		},
+		"sort": []interface{}{
+			map[string]interface{}{"dmca_checked": map[string]interface{}{"order": "asc", "missing": "_first", "ignore_unmapped": true}},
+		},
+	})
+}
+
+func (c *legacyClient) Find(ids []string) ([]searchHit, error) {
+	return c.http.search("/"+searchIndex(c.index, c.alias)+"/upload/_search", idsQuery(ids))
+}
+
+// idsQuery returns the query of the documents with one of ids.
+func idsQuery(ids []string) map[string]interface{} {
+	return map[string]interface{}{
+		"size":  len(ids),
+		"query": map[string]interface{}{"ids": map[string]interface{}{"values": ids}},
+	}
+}
+
+// sendBulk posts documents to the bulk API, convert returns the action and
+// source lines of a document.
+func sendBulk(c *httpClient, docs []goes.Document, convert func(goes.Document) (action, source []byte, err error)) (bulkResponse, error) {
+	if len(docs) == 0 {
+		return bulkResponse{Items: []byte("[]")}, nil
+	}
+	var body bytes.Buffer
+	for _, doc := range docs {
+		action, source, err := convert(doc)
+		if err != nil {
+			return bulkResponse{}, fmt.Errorf("Failed to encode %s %v. (%s)", doc.Type, doc.Id, err.Error())
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(source)
+		body.WriteByte('\n')
+	}
+
+	var r struct {
+		Took   uint64          `json:"took"`
+		Errors bool            `json:"errors"`
+		Items  json.RawMessage `json:"items"`
+	}
+	if err := c.do("POST", "/_bulk", "application/x-ndjson", &body, &r); err != nil {
+		return bulkResponse{}, err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(r.Items, &items); err != nil {
+		return bulkResponse{}, err
+	}
+	if len(items) != len(docs) {
+		return bulkResponse{}, fmt.Errorf("Bulk response has %d items for %d documents.", len(items), len(docs))
+	}
+	return bulkResponse{Took: r.Took, Errors: r.Errors, Items: r.Items}, nil
+}
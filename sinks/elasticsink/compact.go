@@ -0,0 +1,192 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/animezb/goes"
+	"sort"
+)
+
+const (
+	segmentsDocuments = "documents"
+	segmentsCompact   = "compact"
+)
+
+// compactSegmentSize is about the size of a compact segment in a document,
+// without its message id.
+const compactSegmentSize = 48
+
+// CompactSegment is a segment of a file in compaction mode, where segments
+// are listed in the document of their file, or in its chunks, in place of
+// having a document each.
+type CompactSegment struct {
+	MessageId string `json:"message_id"`
+	Part      int    `json:"part"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// Chunk holds the compact segments of a file with more segments than fit in
+// its document. Chunk n holds parts (n-1)*max+1 to n*max, so new segments
+// only rewrite the chunks they fall in.
+type Chunk struct {
+	Chunk    int              `json:"chunk"`
+	Segments []CompactSegment `json:"segments"`
+}
+
+func chunkId(fileId string, chunk int) string {
+	return fmt.Sprintf("%s-%d", fileId, chunk)
+}
+
+func sortSegments(segments []CompactSegment) {
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Part < segments[j].Part })
+}
+
+// compactFile adds the new segments of a file to its state, and lists the
+// segments in the document of the file, or, if the file has more than
+// compactMax parts, in the chunks added segments fall in, which it returns.
+// The state of the upload must be locked.
+func (es *ElasticSink) compactFile(file *File, fs *fileState, added []*Segment, index string) []goes.Document {
+	for _, s := range added {
+		fs.segments = append(fs.segments, CompactSegment{MessageId: s.MessageId, Part: s.Part, Bytes: s.Bytes})
+	}
+	max := es.compactMax
+	chunkOf := func(part int) int {
+		return (part-1)/max + 1
+	}
+	if fs.Length <= max {
+		file.CompactSegments = append([]CompactSegment(nil), fs.segments...)
+		sortSegments(file.CompactSegments)
+		return nil
+	}
+
+	file.Chunks = chunkOf(fs.Length)
+	touched := make(map[int]bool)
+	for _, s := range added {
+		touched[chunkOf(s.Part)] = true
+	}
+	chunks := make(map[int]*Chunk, len(touched))
+	for _, s := range fs.segments {
+		n := chunkOf(s.Part)
+		if n > file.Chunks {
+			// Parts past the length the subject announced.
+			file.Chunks = n
+		}
+		if !touched[n] {
+			continue
+		}
+		if chunks[n] == nil {
+			chunks[n] = &Chunk{Chunk: n}
+		}
+		chunks[n].Segments = append(chunks[n].Segments, s)
+	}
+	numbers := make([]int, 0, len(chunks))
+	for n := range chunks {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	docs := make([]goes.Document, 0, len(chunks))
+	for _, n := range numbers {
+		c := chunks[n]
+		sortSegments(c.Segments)
+		doc, err := json.Marshal(c)
+		if err != nil {
+			es.logger.Printf("Error: Failed to encode chunk %d of file %s. (%s)", n, fs.Id, err.Error())
+			continue
+		}
+		docs = append(docs, goes.Document{
+			Index:       index,
+			Id:          chunkId(fs.Id, n),
+			Type:        "chunk",
+			BulkCommand: "index",
+			Fields:      json.RawMessage(doc),
+			Parent:      fs.Id,
+		})
+	}
+	return docs
+}
+
+// loadSegments loads the compact segments of the buffered files whose state
+// was loaded without them, from the documents of the files and their chunks.
+// Files whose segments couldn't be loaded are merged at the next flush, as
+// writing them would lose the segments.
+func (es *ElasticSink) loadSegments(states map[string]*uploadState, files map[string]File) {
+	type loading struct {
+		st *uploadState
+		fs *fileState
+	}
+	byIndex := make(map[string][]loading)
+	for id, f := range files {
+		st, ok := states[f.ParentId]
+		if !ok {
+			continue
+		}
+		st.lock.Lock()
+		fs, ok := st.files[id]
+		st.lock.Unlock()
+		if ok && !fs.segmentsLoaded {
+			byIndex[st.index] = append(byIndex[st.index], loading{st, fs})
+		}
+	}
+
+	for index, list := range byIndex {
+		ids := make([]string, len(list))
+		parents := make(map[string]string, len(list))
+		for i, l := range list {
+			ids[i] = l.fs.Id
+			parents[l.fs.Id] = l.st.upload.Id
+		}
+		sources, err := es.client.Get(index, "file", ids, parents)
+		if err != nil {
+			es.logger.Printf("Error: Failed to load %d files from %s. (%s)", len(ids), index, err.Error())
+			continue
+		}
+		segments := make(map[string][]CompactSegment, len(list))
+		var chunkIds []string
+		owners := make(map[string]string)
+		failed := make(map[string]bool)
+		for _, l := range list {
+			var f File
+			if source, ok := sources[l.fs.Id]; ok {
+				if err := json.Unmarshal(source, &f); err != nil {
+					es.logger.Printf("Error: Failed to decode file %s. (%s)", l.fs.Id, err.Error())
+					continue
+				}
+			}
+			segments[l.fs.Id] = f.CompactSegments
+			for n := 1; n <= f.Chunks; n++ {
+				chunkIds = append(chunkIds, chunkId(l.fs.Id, n))
+				owners[chunkId(l.fs.Id, n)] = l.fs.Id
+			}
+		}
+		if len(chunkIds) > 0 {
+			chunks, err := es.client.Get(index, "chunk", chunkIds, owners)
+			if err != nil {
+				es.logger.Printf("Error: Failed to load %d chunks from %s. (%s)", len(chunkIds), index, err.Error())
+				continue
+			}
+			for id, source := range chunks {
+				var c Chunk
+				if err := json.Unmarshal(source, &c); err != nil {
+					es.logger.Printf("Error: Failed to decode chunk %s. (%s)", id, err.Error())
+					failed[owners[id]] = true
+					continue
+				}
+				segments[owners[id]] = append(segments[owners[id]], c.Segments...)
+			}
+		}
+		for _, l := range list {
+			s, ok := segments[l.fs.Id]
+			if !ok || failed[l.fs.Id] {
+				continue
+			}
+			l.st.lock.Lock()
+			// The worker or the takedown check may have loaded the segments
+			// meanwhile, and added to them since.
+			if !l.fs.segmentsLoaded {
+				l.fs.segments = s
+				l.fs.segmentsLoaded = true
+			}
+			l.st.lock.Unlock()
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+func TestCompactFile(t *testing.T) {
+	es := &ElasticSink{compactMax: 3, logger: log.New(ioutil.Discard, "", 0)}
+	st := newUploadState("nzb", Upload{Id: "u"})
+	segments := func(parts ...int) []*Segment {
+		s := make([]*Segment, len(parts))
+		for i, part := range parts {
+			s[i] = &Segment{Part: part, MessageId: string(rune('a' + part)), Bytes: 10}
+		}
+		return s
+	}
+
+	// A file of up to compactMax parts lists its segments.
+	fs, added := st.mergeFile(File{Id: "f1", Length: 3, Segments: segments(3, 1)})
+	file := st.file(fs)
+	if chunks := es.compactFile(&file, fs, added, st.index); len(chunks) != 0 {
+		t.Errorf("File of 3 parts has %d chunks", len(chunks))
+	}
+	if len(file.CompactSegments) != 2 || file.CompactSegments[0].Part != 1 || file.Chunks != 0 {
+		t.Errorf("File segments %+v, %d chunks", file.CompactSegments, file.Chunks)
+	}
+
+	// A larger file lists them in chunks, and rewrites the chunks of the new
+	// segments only.
+	fs, added = st.mergeFile(File{Id: "f2", Length: 7, Segments: segments(1, 5, 4, 7)})
+	file = st.file(fs)
+	chunks := es.compactFile(&file, fs, added, st.index)
+	if len(file.CompactSegments) != 0 || file.Chunks != 3 || len(chunks) != 3 {
+		t.Fatalf("File segments %+v, %d chunks, wrote %d", file.CompactSegments, file.Chunks, len(chunks))
+	}
+	var c Chunk
+	json.Unmarshal(chunks[1].Fields.(json.RawMessage), &c)
+	if chunks[1].Id != "f2-2" || chunks[1].Parent != "f2" || chunks[1].Type != "chunk" || c.Chunk != 2 ||
+		len(c.Segments) != 2 || c.Segments[0].Part != 4 || c.Segments[1].Part != 5 {
+		t.Errorf("Chunk %+v, %+v", chunks[1], c)
+	}
+	fs, added = st.mergeFile(File{Id: "f2", Length: 7, Segments: segments(6, 5)})
+	chunks = es.compactFile(&file, fs, added, st.index)
+	json.Unmarshal(chunks[0].Fields.(json.RawMessage), &c)
+	if len(chunks) != 1 || chunks[0].Id != "f2-2" || len(c.Segments) != 3 {
+		t.Errorf("Rewrote %d chunks, %+v", len(chunks), c)
+	}
+}
@@ -0,0 +1,204 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DmcaParams configures the takedown check: every CheckEvery seconds, the
+// sink asks the news server for Sample segments of each of Uploads uploads
+// posted more than MinAge seconds ago, and marks the uploads it misses
+// segments of as taken down.
+type DmcaParams struct {
+	Host       string `json:"host"`
+	SSL        bool   `json:"ssl"`
+	AuthUser   string `json:"auth_user"`
+	AuthPass   string `json:"auth_pass"`
+	CheckEvery int    `json:"check_every"`
+	Uploads    int    `json:"uploads"`
+	Sample     int    `json:"sample"`
+	MinAge     int    `json:"min_age"`
+	// Timeout is the timeout of the connection to the news server, and of
+	// a STAT, in seconds.
+	Timeout int `json:"timeout"`
+}
+
+// withDefaults returns the parameters with the unset ones set to their
+// default.
+func (d DmcaParams) withDefaults() DmcaParams {
+	if d.CheckEvery <= 0 {
+		d.CheckEvery = 3600
+	}
+	if d.Uploads <= 0 {
+		d.Uploads = 100
+	}
+	if d.Sample <= 0 {
+		d.Sample = 8
+	}
+	if d.MinAge <= 0 {
+		d.MinAge = 24 * 3600
+	}
+	if d.Timeout <= 0 {
+		d.Timeout = 30
+	}
+	return d
+}
+
+// checkDmca checks uploads for takedowns until stop is closed.
+func (es *ElasticSink) checkDmca(stop <-chan bool) {
+	ticker := time.NewTicker(time.Duration(es.dmca.CheckEvery) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			es.checkUploads(stop)
+		}
+	}
+}
+
+// checkUploads checks the uploads checked the longest ago. Uploads are only
+// checked once they are MinAge old, so the workers are done merging them.
+func (es *ElasticSink) checkUploads(stop <-chan bool) {
+	d := es.dmca
+	hits, err := es.client.Unchecked(time.Now().Add(-time.Duration(d.MinAge)*time.Second), d.Uploads)
+	if err != nil {
+		es.logger.Printf("Error: Failed to search the uploads to check for takedowns. (%s)", err.Error())
+		return
+	}
+	if len(hits) == 0 {
+		return
+	}
+	timeout := time.Duration(d.Timeout) * time.Second
+	conn, err := dialNNTP(d.Host, d.SSL, d.AuthUser, d.AuthPass, timeout)
+	if err != nil {
+		es.logger.Printf("Error: Failed to connect to %s to check for takedowns. (%s)", d.Host, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	checked, taken, skipped := 0, 0, 0
+	for _, hit := range hits {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		st, _ := es.cachedState(hit.Id)
+		if st == nil {
+			var u Upload
+			if err := json.Unmarshal(hit.Source, &u); err != nil {
+				es.logger.Printf("Error: Failed to decode upload %s. (%s)", hit.Id, err.Error())
+				continue
+			}
+			u.Id = hit.Id
+			st = newUploadState(hit.Index, u)
+		}
+		st.lock.Lock()
+		segments := st.sample(d.Sample)
+		st.lock.Unlock()
+		if len(segments) == 0 {
+			// Nothing to ask the server for, the upload was indexed before
+			// segments were sampled. It is stamped checked anyway, as the
+			// uploads never checked come first and it would hold the others
+			// back forever.
+			skipped++
+			dmcaChecks.WithLabelValues(es.instance, "skipped").Inc()
+			if !es.markChecked(stop, st, nil) {
+				return
+			}
+			continue
+		}
+		missing, err := checkSegments(conn, segments, timeout)
+		if err != nil {
+			// The connection is likely broken, the next check starts over.
+			es.logger.Printf("Error: Failed to check upload %s for takedown. (%s)", hit.Id, err.Error())
+			dmcaChecks.WithLabelValues(es.instance, "error").Inc()
+			break
+		}
+		checked++
+		if len(missing) > 0 {
+			taken++
+			dmcaChecks.WithLabelValues(es.instance, "taken_down").Inc()
+		} else {
+			dmcaChecks.WithLabelValues(es.instance, "available").Inc()
+		}
+		if !es.markChecked(stop, st, missing) {
+			return
+		}
+	}
+	es.logger.Printf("Checked %d uploads for takedowns, %d taken down, %d without samples skipped.", checked, taken, skipped)
+}
+
+// checkSegments asks the server for sampled segments of an upload, it returns
+// the number of segments missing by file.
+func checkSegments(conn *nntpConn, segments []sampledSegment, timeout time.Duration) (map[*fileState]int, error) {
+	missing := make(map[*fileState]int)
+	for _, s := range segments {
+		ok, err := conn.stat(s.messageId, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			missing[s.file]++
+		}
+	}
+	return missing, nil
+}
+
+// dmcaMark is the outcome of the takedown check of an upload. It is applied
+// by the worker owning the upload, which writes it with its next flush, so a
+// flush of the upload serialized before the check can't write over it.
+type dmcaMark struct {
+	uploadId string
+	index    string
+	date     time.Time
+	// missing is the number of sampled segments missing by file id.
+	missing map[string]int
+}
+
+// apply writes the date of the check to the state of the upload, and marks
+// it and the files missing segments as taken down. The state must be locked.
+func (m dmcaMark) apply(st *uploadState) {
+	u := &st.upload
+	date := m.date
+	u.DmcaChecked = &date
+	if len(m.missing) == 0 {
+		return
+	}
+	u.Dmca = true
+	u.DmcaDate = &date
+	u.DmcaMissing = 0
+	for id, n := range m.missing {
+		fs, ok := st.files[id]
+		if !ok {
+			continue
+		}
+		fs.Dmca = true
+		fs.DmcaDate = &date
+		fs.DmcaMissing = n
+		u.DmcaMissing += n
+	}
+}
+
+// markChecked passes the outcome of the check of an upload to the worker
+// owning it. It returns false if stop was closed first.
+func (es *ElasticSink) markChecked(stop <-chan bool, st *uploadState, missing map[*fileState]int) bool {
+	m := dmcaMark{date: time.Now().UTC(), missing: make(map[string]int, len(missing))}
+	st.lock.Lock()
+	m.uploadId, m.index = st.upload.Id, st.index
+	for fs, n := range missing {
+		m.missing[fs.Id] = n
+	}
+	st.lock.Unlock()
+	es.articlesLock.RLock()
+	worker := es.partition(m.uploadId)
+	es.articlesLock.RUnlock()
+	select {
+	case es.marks[worker] <- m:
+		return true
+	case <-stop:
+		return false
+	}
+}
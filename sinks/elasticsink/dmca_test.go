@@ -0,0 +1,29 @@
+package elasticsink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDmcaMarkApply(t *testing.T) {
+	st := newUploadState("nzb", Upload{Id: "u"})
+	st.mergeFile(File{Id: "f1", Length: 2})
+	st.mergeFile(File{Id: "f2", Length: 2})
+	date := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	dmcaMark{uploadId: "u", date: date}.apply(st)
+	if u := st.upload; u.Dmca || u.DmcaChecked == nil || !u.DmcaChecked.Equal(date) {
+		t.Errorf("Available upload marked %v, checked %v", u.Dmca, u.DmcaChecked)
+	}
+
+	dmcaMark{uploadId: "u", date: date, missing: map[string]int{"f2": 3, "gone": 1}}.apply(st)
+	if u := st.upload; !u.Dmca || u.DmcaMissing != 3 || u.DmcaDate == nil {
+		t.Errorf("Taken down upload marked %v, missing %d", u.Dmca, u.DmcaMissing)
+	}
+	if st.files["f1"].Dmca || !st.files["f2"].Dmca || st.files["f2"].DmcaMissing != 3 {
+		t.Errorf("Files marked %v and %v", st.files["f1"].Dmca, st.files["f2"].Dmca)
+	}
+	if _, ok := st.files["gone"]; ok {
+		t.Error("Marked a file unknown to the state")
+	}
+}
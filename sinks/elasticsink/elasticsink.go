@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"github.com/animezb/goes"
 	"github.com/animezb/newsrover"
 	"github.com/animezb/newsroverd/extract"
@@ -14,12 +15,20 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const (
+	overflowBlock = "block"
+	overflowSpill = "spill"
+	overflowDrop  = "drop"
+)
+
 const (
 	lruSize     = 2048
 	MM3_SEED    = 538273
@@ -36,50 +45,198 @@ func init() {
 			return nil, err
 		}
 	})
+	sinks.Register(osSINK_NAME, func(config json.RawMessage) (newsrover.Sink, error) {
+		var conf ElasticSinkParams
+		if err := json.Unmarshal(config, &conf); err == nil {
+			conf.Backend = osSINK_NAME
+			return NewElasticSink(conf)
+		} else {
+			return nil, err
+		}
+	})
 }
 
 type ElasticSink struct {
-	articles     chan newsrover.Article
-	articlesLock sync.RWMutex
-	esConn       *goes.Connection
-	logger       *log.Logger
-	failLog      io.Writer
-	failLogLock  sync.Mutex
-	docBuffSize  int
-	workers      int
-	flushEvery   int
-	processed    int64
-
-	parentLru     *lru.Cache
-	parentLruLock sync.Mutex
-
-	host string
-	port int
+	articles      []chan article
+	articlesLock  sync.RWMutex
+	client        bulkClient
+	logger        *log.Logger
+	failLog       io.Writer
+	failLogLock   sync.Mutex
+	deadLetters   io.Writer
+	docBuffSize   int
+	workers       int
+	flushEvery    int
+	flushBytes    int
+	flushComplete bool
+	compact       bool
+	compactMax    int
+	processed     int64
+	buffered      int64
+
+	retries      int
+	retryBackoff time.Duration
+	// deadLetterFile is the dead letter queue the sink opened, closed when
+	// it stops serving.
+	deadLetterFile *os.File
+
+	queueSize int
+	overflow  string
+	spoolPath string
+	spool     *spool
+	spilled   int64
+	dropped   int64
+	// spilledUploads counts the articles of the uploads in the spill file.
+	spilledUploads map[string]int
+	spillLock      sync.Mutex
+
+	walDir  string
+	walSync bool
+	wal     *wal
+
+	obfuscatedWindow time.Duration
+
+	dmca *DmcaParams
+	// marks pass the outcome of takedown checks to the workers.
+	marks []chan dmcaMark
+
+	replay bool
+
+	states      *lru.Cache
+	statesLock  sync.Mutex
+	stateDBPath string
+	stateDBAge  time.Duration
+	stateDB     *stateDB
+
+	name string
+	// instance is the name of the sink in the configuration, its metrics
+	// are labeled with it.
+	instance  string
+	setupErr  error
+	http      *httpClient
+	index     indexTemplate
+	alias     string
+	bootstrap string
 
 	stop chan bool
+	// stopping is closed when the sink stops, senders counts the Accept
+	// calls waiting for room in a queue without holding articlesLock.
+	stopping chan bool
+	senders  sync.WaitGroup
 }
 
 type ElasticSinkParams struct {
-	Workers int       `json:"workers"`
-	FailLog io.Writer `json:"-"`
+	Workers     int       `json:"workers"`
+	FailLog     io.Writer `json:"-"`
+	FailLogPath string    `json:"fail_log"`
+
+	// Retries is 5 when unset, 0 disables retries.
+	Retries      *int   `json:"retries"`
+	RetryBackoff int    `json:"retry_backoff"`
+	DeadLetter   string `json:"dead_letter"`
+
+	QueueSize int    `json:"queue_size"`
+	Overflow  string `json:"overflow"`
+	Spill     string `json:"spill"`
+
+	Wal     string `json:"wal"`
+	WalSync bool   `json:"wal_sync"`
+
+	// FlushEvery is the interval of the flushes of the workers, in seconds.
+	// A worker flushes earlier once it buffered FlushArticles articles, or
+	// FlushBytes bytes of documents, which is also the maximum size of a
+	// bulk request. FlushComplete flushes as soon as the buffered articles
+	// complete an upload.
+	FlushEvery    int  `json:"flush_every"`
+	FlushArticles int  `json:"flush_articles"`
+	FlushBytes    int  `json:"flush_bytes"`
+	FlushComplete bool `json:"flush_complete"`
+
+	// Segments is documents to write a document per segment, or compact to
+	// list the message id, part and bytes of the segments in the document
+	// of their file instead. The segments of files of more than CompactMax
+	// parts are listed in chunk documents, children of the file, of
+	// CompactMax parts each.
+	Segments   string `json:"segments"`
+	CompactMax int    `json:"compact_max"`
+
+	// StateCache is the number of upload states kept in memory.
+	StateCache int `json:"state_cache"`
+	// StateDB is the bolt database the upload states are saved to, states
+	// not saved for StateDays are removed from it.
+	StateDB   string `json:"state_db"`
+	StateDays int    `json:"state_days"`
+
+	Obfuscated       bool `json:"obfuscated"`
+	ObfuscatedWindow int  `json:"obfuscated_window"`
+
+	// Dmca checks the uploads for takedowns, if its host is set.
+	Dmca *DmcaParams `json:"dmca"`
+
+	// Instance names the sink in its metrics, it defaults to the sink name
+	// and must be set to tell apart sinks of the same name.
+	Instance string `json:"instance"`
+
+	// Replay is set by newsroverd replay, which runs next to the daemon: the
+	// sink leaves the write ahead log, spill file, state database, takedown
+	// checks and metrics to the daemon.
+	Replay bool `json:"replay"`
 
 	ElasticHost string `json:"host"`
 	ElasticPort int    `json:"port"`
+	// Nodes are the URLs of the nodes of the cluster, in place of host and
+	// port. Requests go to each node in turn, and to the next one when a
+	// node can't be reached.
+	Nodes []string `json:"nodes"`
+	// CA is the PEM file of the certificate authorities of https nodes.
+	CA       string `json:"ca"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	ApiKey   string `json:"api_key"`
+	// Timeout is the timeout of a request, in seconds.
+	Timeout int `json:"timeout"`
+
+	// Backend is the cluster the sink writes to, either elasticsearch (1.x)
+	// or opensearch. It is set by the sink name.
+	Backend string `json:"-"`
+	// Index is the name of the index, the opensearch backend adds the type
+	// to it. Dates in braces, as in nzb-{yyyy.MM}, are formatted with the
+	// post date of the upload to roll over to new indices.
+	Index string `json:"index"`
+	// Alias is the search alias of time based indices, it defaults to the
+	// index name without its date.
+	Alias string `json:"alias"`
+	// Bootstrap verifies the indices and their mappings when the sink
+	// starts, and creates or completes them if it is create.
+	Bootstrap string `json:"bootstrap"`
 }
 
 type Upload struct {
-	Id         string         `json:"_id"`
-	Poster     string         `json:"poster"`
-	Subject    string         `json:"subject"`
-	Date       time.Time      `json:"date"`
-	Group      []string       `json:"group"`
-	Dmca       bool           `json:"dmca"`
+	Id      string    `json:"_id"`
+	Poster  string    `json:"poster"`
+	Subject string    `json:"subject"`
+	Date    time.Time `json:"date"`
+	Group   []string  `json:"group"`
+	Dmca    bool      `json:"dmca"`
+	// DmcaDate is when the upload was found taken down, DmcaMissing the
+	// number of sampled segments the news server didn't have, and
+	// DmcaChecked the last time it was checked.
+	DmcaDate    *time.Time `json:"dmca_date,omitempty"`
+	DmcaMissing int        `json:"dmca_missing"`
+	DmcaChecked *time.Time `json:"dmca_checked,omitempty"`
+	// Length is the number of files of the upload, from the [x/y] of its
+	// subjects, and Parts the sum of the lengths of its files, in segments.
 	Length     int            `json:"length"`
+	Parts      int            `json:"parts"`
 	Complete   int            `json:"complete"`
 	Completion float64        `json:"completion"`
 	Size       int64          `json:"size"`
-	Segments   []*Segment     `json:"segments"`
+	FilePrefix string         `json:"fileprefix"`
 	Types      map[string]int `json:"types"`
+	Progress   []*fileState   `json:"progress"`
+
+	Release    extract.ReleaseInfo `json:"release"`
+	Obfuscated bool                `json:"obfuscated"`
 }
 
 type File struct {
@@ -89,12 +246,23 @@ type File struct {
 	Date    time.Time `json:"date"`
 	Group   []string  `json:"group"`
 
-	Length   int   `json:"length"`
-	Complete int   `json:"complete"`
-	Size     int64 `json:"size"`
+	Dmca        bool       `json:"dmca"`
+	DmcaDate    *time.Time `json:"dmca_date,omitempty"`
+	DmcaMissing int        `json:"dmca_missing"`
+
+	Length     int     `json:"length"`
+	Complete   int     `json:"complete"`
+	Completion float64 `json:"completion"`
+	Size       int64   `json:"size"`
 
 	Filename string     `json:"filename"`
-	Segments []*Segment `json:"segments"`
+	Index    int        `json:"index"`
+	Segments []*Segment `json:"-"`
+
+	// CompactSegments lists the segments in compaction mode, unless the file
+	// has Chunks chunks.
+	CompactSegments []CompactSegment `json:"segments,omitempty"`
+	Chunks          int              `json:"chunks,omitempty"`
 
 	ParentId string `json:"-"`
 }
@@ -110,111 +278,106 @@ type Segment struct {
 	Bytes           int64     `json:"bytes"`
 	Part            int       `json:"part"`
 	Length          int       `json:"length"`
+	File            int       `json:"file"`
 	Added           time.Time `json:"added"`
 }
 
-type updateCommit struct {
-	Script string      `json:"script"`
-	Params interface{} `json:"params"`
-	Lang   string      `json:"lang"`
-	Upsert interface{} `json:"upsert,omitempty"`
+// article is an accepted newsrover.Article along with what was extracted
+// from its subject, so extraction runs once per article.
+type article struct {
+	newsrover.Article
+	release    string
+	filename   string
+	parts      extract.Parts
+	claimed    bool
+	obfuscated bool
+	uploadId   string
+	wal        *walSegment
 }
 
-func createSegment(article newsrover.Article) Segment {
+func newArticle(a newsrover.Article) article {
+	s := extract.ExtractSubject(a.Group, a.Subject)
+	return article{
+		Article:  a,
+		release:  s.Release,
+		filename: s.Filename,
+		parts:    s.Parts,
+		claimed:  s.Claimed(),
+	}
+}
+
+// obfuscate files the article under its poster's obfuscated upload for the
+// time window it was posted in, in place of its release.
+func (a *article) obfuscate(window time.Duration) {
+	a.obfuscated = true
+	a.release = "obfuscated " + a.Time().Truncate(window).UTC().Format(time.RFC3339)
+}
+
+func createSegment(a article) Segment {
 	return Segment{
-		Group:           article.Group,
-		Subject:         article.Subject,
-		Filename:        extract.ExtractFile(article.Subject),
-		Poster:          article.From,
-		Date:            article.Time(),
-		ServerArticleId: int64(article.ArticleId),
-		MessageId:       article.MessageId,
-		Bytes:           article.Bytes,
-		Length:          extract.ExtractYencLength(article.Subject),
-		Part:            extract.ExtractYencPart(article.Subject),
+		Group:           a.Group,
+		Subject:         a.Subject,
+		Filename:        a.filename,
+		Poster:          a.From,
+		Date:            a.Time(),
+		ServerArticleId: int64(a.ArticleId),
+		MessageId:       a.MessageId,
+		Bytes:           a.Bytes,
+		Length:          a.parts.Total,
+		Part:            a.parts.Part,
+		File:            a.parts.File,
 		Added:           time.Now().UTC(),
 	}
 }
 
-func createFile(article newsrover.Article) File {
+func createFile(a article) File {
 	return File{
-		Id:      articleFileUploadId(article),
-		Poster:  article.From,
-		Subject: article.Subject,
-		Date:    article.Time(),
-		Group:   []string{article.Group},
+		Id:      articleFileUploadId(a),
+		Poster:  a.From,
+		Subject: a.Subject,
+		Date:    a.Time(),
+		Group:   []string{a.Group},
 
-		Length:   extract.ExtractYencLength(article.Subject),
+		Length:   a.parts.Total,
 		Complete: 0,
-		Filename: extract.ExtractFile(article.Subject),
-
-		Segments: make([]*Segment, 0, 16),
-	}
-}
+		Filename: a.filename,
+		Index:    a.parts.File,
 
-func createUpload(article newsrover.Article) Upload {
-	return Upload{
-		Id:       articleUploadId(article),
-		Poster:   article.From,
-		Subject:  article.Subject,
-		Date:     article.Time(),
-		Group:    []string{article.Group},
-		Dmca:     false,
-		Length:   extract.ExtractYencLength(article.Subject),
-		Complete: 0,
-		Size:     0,
 		Segments: make([]*Segment, 0, 16),
 	}
 }
 
-func fileUpdateCommit(f File) updateCommit {
-	return updateCommit{
-		Script: "roversinkscript",
-		Lang:   "native",
-		Params: struct {
-			File
-			Type string `json:"type"`
-		}{
-			File: f,
-			Type: "file",
-		},
-		Upsert: struct {
-			placeholder bool `json:"-"`
-		}{true},
+func createUpload(a article) Upload {
+	u := Upload{
+		Id:         articleUploadId(a),
+		Poster:     a.From,
+		Subject:    a.Subject,
+		Date:       a.Time(),
+		Group:      []string{a.Group},
+		Dmca:       false,
+		Length:     a.parts.Files,
+		Obfuscated: a.obfuscated,
 	}
-}
-
-func uploadUpdateCommit(u Upload) updateCommit {
-	return updateCommit{
-		Script: "roversinkscript",
-		Lang:   "native",
-		Params: struct {
-			Upload
-			Type string `json:"type"`
-		}{
-			Upload: u,
-			Type:   "upload",
-		},
-		Upsert: struct {
-			Dmca bool `json:"dmca"`
-		}{false},
+	if !a.obfuscated {
+		u.Release = extract.ParseRelease(a.release, a.filename)
 	}
+	return u
 }
 
-func articleUploadId(article newsrover.Article) string {
+func articleUploadId(a article) string {
 	h128 := murmur3.New64(MM3_SEED)
-	h128.Write([]byte(article.From))
-	h128.Write([]byte(extract.ExtractRelease(article.Group, article.Subject)))
+	h128.Write([]byte(a.From))
+	h128.Write([]byte(a.release))
 	return hex.EncodeToString(h128.Sum(nil))
 }
 
-func articleFileUploadId(article newsrover.Article) string {
+func articleFileUploadId(a article) string {
 	h128 := murmur3.New64(MM3_SEED)
-	h128.Write([]byte(article.From))
-	h128.Write([]byte(extract.ExtractRelease(article.Group, article.Subject)))
-	h128.Write([]byte(extract.ExtractFile(article.Subject)))
+	h128.Write([]byte(a.From))
+	h128.Write([]byte(a.release))
+	h128.Write([]byte(a.filename))
 	b := make([]byte, 4)
-	binary.LittleEndian.PutUint32(b, uint32(extract.ExtractYencLength(article.Subject)))
+	binary.LittleEndian.PutUint32(b, uint32(a.parts.Total))
 	h128.Write(b)
 	return hex.EncodeToString(h128.Sum(nil))
 }
@@ -223,8 +386,8 @@ func (es *ElasticSink) Accept(articles []newsrover.Article) {
 	// Not really sure of the performance hit of this lock here
 	// (Prevents send on nil channel if sink isn't serving...)
 	es.articlesLock.RLock()
-	defer es.articlesLock.RUnlock()
 	if es.articles == nil {
+		es.articlesLock.RUnlock()
 		es.logger.Println("Recieved accept when uninitialized.")
 		i := make([]interface{}, len(articles))
 		for idx, a := range articles {
@@ -233,49 +396,405 @@ func (es *ElasticSink) Accept(articles []newsrover.Article) {
 		es.FailAll(i)
 		return
 	}
-	g := int64(0)
-	for i, a := range articles {
-		if strings.Contains(strings.ToLower(a.Subject), "yenc") {
-			if extract.ExtractRelease(a.Group, a.Subject) != "" {
-				es.articles <- a
+	prepared := make([]article, 0, len(articles))
+	for _, a := range articles {
+		if art, ok := es.prepare(a); ok {
+			prepared = append(prepared, art)
+		}
+	}
+	acceptedArticles.WithLabelValues(es.instance).Add(float64(len(articles)))
+	filteredArticles.WithLabelValues(es.instance).Add(float64(len(articles) - len(prepared)))
+	es.logAhead(prepared)
+	var blocked []article
+	for _, art := range prepared {
+		// Once an article waits for room, the ones after it wait as well,
+		// so they reach their worker in order.
+		if len(blocked) > 0 || !es.enqueue(es.articles[es.partition(art.uploadId)], art) {
+			blocked = append(blocked, art)
+		}
+	}
+	if len(blocked) == 0 {
+		es.articlesLock.RUnlock()
+		atomic.AddInt64(&es.processed, int64(len(articles)))
+		return
+	}
+	// The lock isn't held while waiting, so the sink can stop.
+	queues, stopping := es.articles, es.stopping
+	es.senders.Add(1)
+	es.articlesLock.RUnlock()
+	defer es.senders.Done()
+	for i, art := range blocked {
+		select {
+		case queues[es.partition(art.uploadId)] <- art:
+		case <-stopping:
+			for _, a := range blocked[i:] {
+				es.abandon(a)
 			}
+			return
 		}
-		if i%50 == 0 {
-			atomic.AddInt64(&es.processed, 50)
-			g += 50
+	}
+	atomic.AddInt64(&es.processed, int64(len(articles)))
+}
+
+// abandon gives up on an article still waiting for its worker when the sink
+// stops. Articles in the write ahead log are recovered from it at the next
+// start, the others are written to the fail log.
+func (es *ElasticSink) abandon(a article) {
+	if a.wal == nil {
+		es.Fail(a.Article)
+	}
+}
+
+// logAhead writes articles to the write ahead log, if there is one.
+func (es *ElasticSink) logAhead(articles []article) {
+	if es.wal == nil || len(articles) == 0 {
+		return
+	}
+	raw := make([]newsrover.Article, len(articles))
+	for i, a := range articles {
+		raw[i] = a.Article
+	}
+	seg, err := es.wal.append(raw)
+	if err != nil {
+		es.logger.Printf("Error: Failed to write %d articles to the write ahead log. (%s)", len(articles), err.Error())
+		return
+	}
+	for i := range articles {
+		articles[i].wal = seg
+	}
+}
+
+// logged marks an article that is no longer queued as done in the write
+// ahead log.
+func (es *ElasticSink) logged(a article) {
+	if a.wal != nil {
+		es.wal.release(a.wal, 1)
+	}
+}
+
+// prepare extracts the release of a yEnc article, it returns false if the
+// article is not indexed.
+func (es *ElasticSink) prepare(a newsrover.Article) (article, bool) {
+	if !strings.Contains(strings.ToLower(a.Subject), "yenc") {
+		return article{}, false
+	}
+	art := newArticle(a)
+	// Subjects a rule claimed are indexed under their release.
+	if es.obfuscatedWindow > 0 && !art.claimed && extract.IsObfuscated(a.Subject) {
+		art.obfuscate(es.obfuscatedWindow)
+	}
+	if art.release == "" {
+		return art, false
+	}
+	art.uploadId = articleUploadId(art)
+	return art, true
+}
+
+// enqueue queues an article for its worker without waiting. An article whose
+// queue is full is handled according to the overflow policy: spilled to disk
+// until the workers catch up, or written to the fail log. It returns false if
+// the policy is to wait for the worker.
+func (es *ElasticSink) enqueue(queue chan<- article, a article) bool {
+	if es.overflow == overflowSpill {
+		es.spillLock.Lock()
+		defer es.spillLock.Unlock()
+		// The articles of an upload with spilled articles are spilled
+		// after them, so its worker gets them in order.
+		if es.spilledUploads[a.uploadId] > 0 {
+			es.spill(a)
+			return true
+		}
+	}
+	select {
+	case queue <- a:
+		return true
+	default:
+	}
+	switch es.overflow {
+	case overflowSpill:
+		es.spill(a)
+	case overflowDrop:
+		es.drop(a)
+	default:
+		return false
+	}
+	return true
+}
+
+// spill writes an article to the spill file, spillLock must be held.
+func (es *ElasticSink) spill(a article) {
+	if err := es.spool.push(a.Article); err != nil {
+		es.logger.Printf("Error: Failed to spill article %s. (%s)", a.MessageId, err.Error())
+		es.drop(a)
+		return
+	}
+	es.spilledUploads[a.uploadId]++
+	atomic.AddInt64(&es.spilled, 1)
+	es.logged(a)
+}
+
+func (es *ElasticSink) drop(a article) {
+	atomic.AddInt64(&es.dropped, 1)
+	es.Fail(a.Article)
+	es.logged(a)
+}
+
+// drain feeds the spilled articles back to the workers, until stop is closed.
+// The queues are only closed once it returned.
+func (es *ElasticSink) drain(stop <-chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-es.spool.ready:
+		}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			a, ok, err := es.spool.peek()
+			if err != nil {
+				es.logger.Printf("Error: Failed to read spilled articles. (%s)", err.Error())
+			}
+			if !ok {
+				break
+			}
+			if art, ok := es.prepare(a); ok {
+				prepared := []article{art}
+				es.logAhead(prepared)
+				select {
+				case es.articles[es.partition(art.uploadId)] <- prepared[0]:
+				case <-stop:
+					// Left in the spill file for the next start.
+					es.logged(prepared[0])
+					return
+				}
+				// Only once it is queued, so the next articles of the
+				// upload aren't queued before it.
+				es.spillLock.Lock()
+				if n := es.spilledUploads[art.uploadId]; n > 1 {
+					es.spilledUploads[art.uploadId] = n - 1
+				} else {
+					delete(es.spilledUploads, art.uploadId)
+				}
+				es.spillLock.Unlock()
+			}
+			if _, _, err := es.spool.pop(); err != nil {
+				es.logger.Printf("Error: Failed to read spilled articles. (%s)", err.Error())
+			}
 		}
 	}
-	atomic.AddInt64(&es.processed, int64(len(articles))-g)
+}
+
+// QueueDepth returns the number of articles waiting for a worker, in memory
+// and spilled to disk.
+func (es *ElasticSink) QueueDepth() (queued int, spilled int64) {
+	es.articlesLock.RLock()
+	for _, c := range es.articles {
+		queued += len(c)
+	}
+	es.articlesLock.RUnlock()
+	if es.spool != nil {
+		spilled = es.spool.Len()
+	}
+	return queued, spilled
+}
+
+// partition returns the worker responsible for an upload. Every article of an
+// upload goes to the same worker so its upload and file documents are only
+// ever created and updated by one worker, in order.
+func (es *ElasticSink) partition(uploadId string) int {
+	h, _ := strconv.ParseUint(uploadId, 16, 64)
+	return int(h % uint64(len(es.articles)))
 }
 
 func NewElasticSink(params ElasticSinkParams) (*ElasticSink, error) {
+	if params.Replay {
+		params.Wal = ""
+		params.StateDB = ""
+		params.Dmca = nil
+		if params.Overflow == overflowSpill {
+			params.Overflow = overflowBlock
+		}
+	}
 	es := &ElasticSink{}
+	es.replay = params.Replay
 	es.logger = log.New(ioutil.Discard, "", log.LstdFlags)
-	es.parentLru = lru.New(lruSize)
 	es.docBuffSize = 4096
 	es.workers = 1
 	es.failLog = ioutil.Discard
-	es.host = "localhost"
-	es.port = 9200
+	es.name = esSINK_NAME
+	es.index, _ = parseIndexTemplate(ES_INDEX)
 	es.flushEvery = 90
+	es.retries = 5
+	es.retryBackoff = 500 * time.Millisecond
+	es.queueSize = 4096
+	es.overflow = overflowBlock
 	es.articles = nil
 	if params.Workers > 0 {
 		es.workers = params.Workers
 	}
+	if params.QueueSize > 0 {
+		es.queueSize = params.QueueSize
+	}
+	if es.queueSize < es.workers {
+		// Every worker needs room in its queue.
+		return nil, fmt.Errorf("Queue size %d is smaller than the %d workers.", es.queueSize, es.workers)
+	}
+	switch params.Overflow {
+	case "", overflowBlock:
+	case overflowSpill:
+		es.overflow = overflowSpill
+		switch {
+		case params.Spill != "":
+			es.spoolPath = params.Spill
+		case params.Wal != "":
+			es.spoolPath = filepath.Join(params.Wal, "spill.log")
+		default:
+			return nil, fmt.Errorf("Overflow policy spill needs a spill file.")
+		}
+	case overflowDrop:
+		es.overflow = overflowDrop
+	default:
+		return nil, fmt.Errorf("Unknown overflow policy %s.", params.Overflow)
+	}
+	if params.FlushEvery > 0 {
+		es.flushEvery = params.FlushEvery
+	}
+	if params.FlushArticles > 0 {
+		es.docBuffSize = params.FlushArticles
+	}
+	es.flushBytes = params.FlushBytes
+	es.flushComplete = params.FlushComplete
+	switch params.Segments {
+	case "", segmentsDocuments:
+	case segmentsCompact:
+		es.compact = true
+	default:
+		return nil, fmt.Errorf("Unknown segments mode %s.", params.Segments)
+	}
+	es.compactMax = 1000
+	if params.CompactMax > 0 {
+		es.compactMax = params.CompactMax
+	}
+	es.walDir = params.Wal
+	es.walSync = params.WalSync
+	if params.StateCache > 0 {
+		es.states = lru.New(params.StateCache)
+	} else {
+		es.states = lru.New(lruSize)
+	}
+	es.stateDBPath = params.StateDB
+	es.stateDBAge = 30 * 24 * time.Hour
+	if params.StateDays > 0 {
+		es.stateDBAge = time.Duration(params.StateDays) * 24 * time.Hour
+	}
+	if params.Retries != nil {
+		if *params.Retries < 0 {
+			return nil, fmt.Errorf("Negative number of retries %d.", *params.Retries)
+		}
+		es.retries = *params.Retries
+	}
+	if params.RetryBackoff > 0 {
+		es.retryBackoff = time.Duration(params.RetryBackoff) * time.Millisecond
+	}
+	conf := httpConfig{
+		nodes:    params.Nodes,
+		ca:       params.CA,
+		username: params.Username,
+		password: params.Password,
+		apiKey:   params.ApiKey,
+		timeout:  time.Duration(params.Timeout) * time.Second,
+	}
+	if len(conf.nodes) == 0 {
+		host, port := "localhost", 9200
+		if params.ElasticHost != "" {
+			host = params.ElasticHost
+		}
+		if params.ElasticPort > 0 {
+			port = params.ElasticPort
+		}
+		conf.nodes = []string{fmt.Sprintf("http://%s:%d", host, port)}
+	}
+	client, err := newHttpClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	es.http = client
+	switch params.Backend {
+	case "", esSINK_NAME:
+	case osSINK_NAME:
+		es.name = osSINK_NAME
+	default:
+		return nil, fmt.Errorf("Unknown backend %s.", params.Backend)
+	}
+	es.instance = es.name
+	if params.Instance != "" {
+		es.instance = params.Instance
+	}
+	if params.Index != "" {
+		index, err := parseIndexTemplate(params.Index)
+		if err != nil {
+			return nil, err
+		}
+		es.index = index
+	}
+	if es.index.timeBased() {
+		es.alias = es.index.alias()
+		if params.Alias != "" {
+			es.alias = params.Alias
+		}
+		if es.alias == "" {
+			return nil, fmt.Errorf("Index %s needs an alias.", es.index)
+		}
+	}
+	switch params.Bootstrap {
+	case "", bootstrapVerify, bootstrapCreate:
+		es.bootstrap = params.Bootstrap
+	default:
+		return nil, fmt.Errorf("Unknown bootstrap mode %s.", params.Bootstrap)
+	}
+	if params.Obfuscated {
+		es.obfuscatedWindow = time.Hour
+		if params.ObfuscatedWindow > 0 {
+			es.obfuscatedWindow = time.Duration(params.ObfuscatedWindow) * time.Second
+		}
+	}
+	if params.Dmca != nil && params.Dmca.Host != "" {
+		dmca := params.Dmca.withDefaults()
+		es.dmca = &dmca
+	}
+	// The files are opened last, so an invalid parameter doesn't leak them.
+	var failLogFile *os.File
 	if params.FailLog != nil {
 		es.failLog = params.FailLog
+	} else if params.FailLogPath != "" {
+		f, err := os.OpenFile(params.FailLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open fail log %s. (%s)", params.FailLogPath, err.Error())
+		}
+		es.failLog = f
+		failLogFile = f
 	}
-	if params.ElasticHost != "" {
-		es.host = params.ElasticHost
-	}
-	if params.ElasticPort > 0 {
-		es.port = params.ElasticPort
+	es.deadLetters = es.failLog
+	if params.DeadLetter != "" {
+		f, err := os.OpenFile(params.DeadLetter, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			if failLogFile != nil {
+				failLogFile.Close()
+			}
+			return nil, fmt.Errorf("Failed to open dead letter queue %s. (%s)", params.DeadLetter, err.Error())
+		}
+		es.deadLetters = f
+		es.deadLetterFile = f
 	}
 	return es, nil
 }
 
 func (es *ElasticSink) Name() string {
-	return esSINK_NAME
+	return es.name
 }
 
 func (es *ElasticSink) SetLogger(logger *log.Logger) {
@@ -289,8 +808,8 @@ func (es *ElasticSink) SetLogger(logger *log.Logger) {
 	}
 }
 
-func (es *ElasticSink) Fail(article newsrover.Article) {
-	es.FailAll([]interface{}{article})
+func (es *ElasticSink) Fail(a newsrover.Article) {
+	es.FailAll([]interface{}{a})
 }
 
 func (es *ElasticSink) FailAll(articles []interface{}) {
@@ -303,7 +822,18 @@ func (es *ElasticSink) FailAll(articles []interface{}) {
 	es.failLogLock.Unlock()
 }
 
-func (es *ElasticSink) serve(stop <-chan bool) {
+// bulkFlush is a bulk request, and the articles of the write ahead log it
+// indexes.
+type bulkFlush struct {
+	docs []goes.Document
+	wal  map[*walSegment]int64
+	// compacted is the number of segments listed in the files of docs.
+	compacted int
+	// saved are the states of the uploads of docs, saved once written.
+	saved []savedState
+}
+
+func (es *ElasticSink) serve(articles <-chan article, marks <-chan dmcaMark) {
 	flushTime := time.Duration(es.flushEvery) * time.Second
 	flush := time.NewTimer(flushTime)
 	bfSz := es.docBuffSize
@@ -313,29 +843,37 @@ func (es *ElasticSink) serve(stop <-chan bool) {
 	articleCount := 0
 	uploadBuffer := make(map[string]Upload)
 	fileBuffer := make(map[string]File)
+	indexBuffer := make(map[string]string)
 	segmentBuffer := make([]goes.Document, 0, bfSz+1)
-	flushQueue := make(chan []goes.Document, 1)
-	errorFile, _ := os.OpenFile("eserrors.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	// segmentUploads holds the upload of every buffered segment.
+	segmentUploads := make([]string, 0, bfSz+1)
+	bufferedBytes := 0
+	progress := make(map[string]*bufferedProgress)
+	// walBuffer counts the articles of the buffered uploads by write ahead
+	// log segment, a segment is released when the uploads are merged.
+	walBuffer := make(map[string]map[*walSegment]int64)
+	// markBuffer holds the takedown marks applied and written with the next
+	// flush, along with their upload and the files they mark.
+	markBuffer := make(map[string]dmcaMark)
+	flushQueue := make(chan *bulkFlush, 1)
+	flushed := make(chan bool)
 
 	go func() {
+		defer close(flushed)
 		for {
 			select {
-			case docs := <-flushQueue:
-				if docs != nil {
-					es.logger.Printf("Indexing and updating %d documents.", len(docs))
-					if r, err := es.esConn.BulkSend(ES_INDEX, docs); err != nil {
-						es.logger.Printf("Error: Failed to bulk flush %d articles. %s", len(docs), err.Error())
-						i := make([]interface{}, len(docs))
-						for idx, d := range docs {
-							i[idx] = d
-						}
-						es.FailAll(i)
-					} else {
-						es.logger.Printf("Flushed %d documents took %dms. (%d)", len(docs), r.Took, es.processed)
-						if r.Errors {
-							errorFile.Write(r.Items)
-							errorFile.WriteString("\n")
-						}
+			case f := <-flushQueue:
+				if f != nil {
+					es.logger.Printf("Indexing and updating %d documents.", len(f.docs))
+					dead := es.bulkSend(f.docs)
+					if len(dead) == 0 {
+						indexedArticles.WithLabelValues(es.instance).Add(float64(f.compacted))
+					}
+					es.saveStates(f.saved, dead)
+					// The articles are in ElasticSearch or in the dead
+					// letter queue now.
+					for seg, n := range f.wal {
+						es.wal.release(seg, n)
 					}
 				} else {
 					return
@@ -346,193 +884,587 @@ func (es *ElasticSink) serve(stop <-chan bool) {
 
 	flushDocuments := func() {
 		/*
-		 * The purpose of the LRU is to create documents that will then be
-		 * properly updated by the RoverSinkScript that exists on the
-		 * ElasticSearch Server. We decide to "create" the documents
-		 * if we aren't sure they exist (we keep an LRU of created documents).
-		 *
-		 * The only issue is the entire process isn't threadsafe and this
-		 * is what limits us to have only one worker in the sink.
+		 * Uploads and files are merged with the segments of the flush in
+		 * their state, which is cached and loaded from the cluster when it
+		 * isn't, and written whole, so the cluster needs no update script.
 		 *
-		 * A race issue occurs if 1 worker buffers the create command
-		 * and before it is sent to ElasticSearch, another worker
-		 * issues an update command on that same document to be created.
+		 * A race issue would occur if 2 workers merged segments of the same
+		 * upload, each writing the upload without the segments of the
+		 * other. Accept partitions articles by upload id, so every upload
+		 * (and its files) belongs to a single worker.
 		 *
-		 * This causes the update command to fail, and we lose data.
+		 * In compaction mode, segments are listed in their file instead of
+		 * being written, and the lists of files whose state was loaded are
+		 * loaded first.
 		 */
-		if len(segmentBuffer) > 0 {
-			createParentDocs := make([]goes.Document, 0, len(uploadBuffer)+len(fileBuffer))
-			docs := make([]goes.Document, len(uploadBuffer)+len(fileBuffer)+len(segmentBuffer))
-			z := 0
-			es.parentLruLock.Lock()
-			for _, v := range uploadBuffer {
-				if _, ok := es.parentLru.Get(v.Id); !ok {
-					createParentDocs = append(createParentDocs, goes.Document{
-						Index:       ES_INDEX,
-						Id:          v.Id,
-						Type:        "upload",
-						BulkCommand: "create",
-						Fields: struct {
-							Dmca bool `json:"dmca"`
-						}{false},
-					})
-					es.parentLru.Add(v.Id, true)
-				}
-				docs[z] = goes.Document{
-					Index:       ES_INDEX,
-					Type:        "upload",
-					Id:          v.Id,
-					BulkCommand: "update",
-					Fields:      uploadUpdateCommit(v),
+		if articleCount > 0 || len(fileBuffer) > 0 || len(markBuffer) > 0 {
+			states := es.uploadStates(uploadBuffer, indexBuffer)
+			if es.compact {
+				es.loadSegments(states, fileBuffer)
+			}
+			for id, m := range markBuffer {
+				st, ok := states[id]
+				if !ok {
+					continue
 				}
-				z++
+				st.lock.Lock()
+				m.apply(st)
+				st.lock.Unlock()
+				delete(markBuffer, id)
 			}
-			for _, v := range fileBuffer {
-				if _, ok := es.parentLru.Get(v.Id); !ok {
-					createParentDocs = append(createParentDocs, goes.Document{
-						Index:       ES_INDEX,
-						Id:          v.Id,
+			docs := make([]goes.Document, 0, len(uploadBuffer)+len(fileBuffer)+len(segmentBuffer))
+			carried := make(map[string]bool)
+			compacted := 0
+			for id, v := range fileBuffer {
+				st, ok := states[v.ParentId]
+				if !ok {
+					continue
+				}
+				st.lock.Lock()
+				if _, ok := st.files[id]; !ok && len(v.Segments) == 0 {
+					// Marked for a takedown, but unknown to the state.
+					st.lock.Unlock()
+					delete(fileBuffer, id)
+					continue
+				}
+				if fs, ok := st.files[id]; ok && es.compact && !fs.segmentsLoaded {
+					st.lock.Unlock()
+					carried[v.ParentId] = true
+					continue
+				}
+				fs, added := st.mergeFile(v)
+				file := st.file(fs)
+				var chunks []goes.Document
+				if es.compact {
+					chunks = es.compactFile(&file, fs, added, st.index)
+					compacted += len(added)
+				}
+				doc, err := json.Marshal(file)
+				st.lock.Unlock()
+				if err != nil {
+					es.logger.Printf("Error: Failed to encode file %s. (%s)", id, err.Error())
+				} else {
+					docs = append(docs, goes.Document{
+						Index:       st.index,
+						Id:          id,
 						Type:        "file",
-						BulkCommand: "create",
+						BulkCommand: "index",
+						Fields:      json.RawMessage(doc),
 						Parent:      v.ParentId,
-						Fields: struct {
-							placeholder bool `json:"-"`
-						}{true},
 					})
-					es.parentLru.Add(v.Id, true)
-				}
-				docs[z] = goes.Document{
-					Index:       ES_INDEX,
-					Id:          v.Id,
-					Type:        "file",
-					BulkCommand: "update",
-					Fields:      fileUpdateCommit(v),
-					Parent:      v.ParentId,
+					docs = append(docs, chunks...)
 				}
-				z++
+				delete(fileBuffer, id)
 			}
-			es.parentLruLock.Unlock()
-			for _, v := range segmentBuffer {
-				docs[z] = v
-				z++
+			saved := make([]savedState, 0, len(uploadBuffer))
+			for id, v := range uploadBuffer {
+				st, ok := states[id]
+				if !ok {
+					// Merged at the next flush.
+					continue
+				}
+				st.lock.Lock()
+				st.mergeUpload(v)
+				doc, err := json.Marshal(st.upload)
+				st.lock.Unlock()
+				if err != nil {
+					es.logger.Printf("Error: Failed to encode upload %s. (%s)", id, err.Error())
+				} else {
+					docs = append(docs, goes.Document{
+						Index:       st.index,
+						Type:        "upload",
+						Id:          id,
+						BulkCommand: "index",
+						Fields:      json.RawMessage(doc),
+					})
+					saved = append(saved, savedState{id: id, index: st.index, doc: doc})
+				}
+				if carried[id] {
+					// Written again with the files merged at the next flush.
+					continue
+				}
+				delete(uploadBuffer, id)
+				delete(indexBuffer, id)
 			}
-			if len(createParentDocs) > 0 {
-				flushQueue <- createParentDocs
+			for i, id := range segmentUploads {
+				// Segments go to the index their upload was found in.
+				if st, ok := states[id]; ok {
+					segmentBuffer[i].Index = st.index
+				}
 			}
-			flushQueue <- docs
-			for k := range uploadBuffer {
-				delete(uploadBuffer, k)
+			docs = append(docs, segmentBuffer...)
+			flushSizes.WithLabelValues(es.instance).Observe(float64(len(docs)))
+			f := &bulkFlush{docs: docs, compacted: compacted, saved: saved}
+			released := make(map[*walSegment]int64)
+			for id, segments := range walBuffer {
+				if _, ok := uploadBuffer[id]; ok {
+					// Pinned until the upload is merged.
+					continue
+				}
+				for seg, n := range segments {
+					released[seg] += n
+				}
+				delete(walBuffer, id)
 			}
-			for k := range fileBuffer {
-				delete(fileBuffer, k)
+			if len(released) > 0 {
+				es.wal.rotate()
+				f.wal = released
 			}
+			flushQueue <- f
 			segmentBuffer = segmentBuffer[:0]
+			segmentUploads = segmentUploads[:0]
+			bufferedBytes = 0
+			if es.flushBytes > 0 {
+				// Carried uploads and files are written again.
+				for _, v := range uploadBuffer {
+					bufferedBytes += es.uploadDocSize(v)
+				}
+				for _, v := range fileBuffer {
+					bufferedBytes += es.fileDocSize(v)
+				}
+			}
+			progress = make(map[string]*bufferedProgress)
+			atomic.AddInt64(&es.buffered, -int64(articleCount))
 			articleCount = 0
 		}
 	}
 
+	bufferMark := func(m dmcaMark) {
+		markBuffer[m.uploadId] = m
+		if _, ok := uploadBuffer[m.uploadId]; !ok {
+			uploadBuffer[m.uploadId] = Upload{Id: m.uploadId}
+		}
+		if _, ok := indexBuffer[m.uploadId]; !ok {
+			indexBuffer[m.uploadId] = m.index
+		}
+		for id := range m.missing {
+			if _, ok := fileBuffer[id]; !ok {
+				fileBuffer[id] = File{Id: id, ParentId: m.uploadId}
+			}
+		}
+	}
+
 	for {
 		select {
-		case <-stop:
-			flushDocuments()
-			flush.Stop()
-			flushQueue <- nil
-			return
 		case <-flush.C:
 			flushDocuments()
 			flush.Reset(flushTime)
-		case article, ok := <-es.articles:
-			if ok {
+		case m := <-marks:
+			bufferMark(m)
+		case a, ok := <-articles:
+			if !ok {
+				// The takedown check is stopped before the workers.
+				for len(marks) > 0 {
+					bufferMark(<-marks)
+				}
+				flushDocuments()
+				// Uploads whose state couldn't be loaded are tried again
+				// before giving up.
+				backoff := es.retryBackoff
+				for attempt := 1; len(uploadBuffer) > 0 && attempt <= es.retries; attempt++ {
+					time.Sleep(backoff)
+					if backoff *= 2; backoff > maxRetryBackoff {
+						backoff = maxRetryBackoff
+					}
+					flushDocuments()
+				}
+				if len(uploadBuffer) > 0 && es.wal != nil {
+					es.logger.Printf("Error: Stopping without merging %d uploads, their articles are kept in the write ahead log.", len(uploadBuffer))
+				} else if len(uploadBuffer) > 0 {
+					es.logger.Printf("Error: Stopping without merging %d uploads.", len(uploadBuffer))
+				}
+				flush.Stop()
+				flushQueue <- nil
+				<-flushed
+				return
+			} else {
 				articleCount++
-				uploadId := articleUploadId(article)
-				fileUploadId := articleFileUploadId(article)
-
+				atomic.AddInt64(&es.buffered, 1)
+				if a.wal != nil {
+					segments, ok := walBuffer[a.uploadId]
+					if !ok {
+						segments = make(map[*walSegment]int64)
+						walBuffer[a.uploadId] = segments
+					}
+					segments[a.wal]++
+				}
 				segment := new(Segment)
-				*segment = createSegment(article)
-
-				segmentDoc := goes.Document{
-					Index:       ES_INDEX,
-					Id:          segment.MessageId,
-					Type:        "segment",
-					BulkCommand: "create",
-					Fields:      *segment,
-					Parent:      fileUploadId,
+				*segment = createSegment(a)
+				var fields []byte
+				if !es.compact {
+					var err error
+					if fields, err = json.Marshal(segment); err != nil {
+						es.logger.Printf("Error: Failed to encode segment %s. (%s)", segment.MessageId, err.Error())
+						es.Fail(a.Article)
+						continue
+					}
+				}
+				uploadId := a.uploadId
+				fileUploadId := articleFileUploadId(a)
+				index, ok := indexBuffer[uploadId]
+				if !ok {
+					index = es.uploadIndex(uploadId, a.Time())
+					indexBuffer[uploadId] = index
+				}
+
+				if es.compact {
+					bufferedBytes += compactSegmentSize + len(segment.MessageId)
+				} else {
+					segmentDoc := goes.Document{
+						Index:       index,
+						Id:          segment.MessageId,
+						Type:        "segment",
+						BulkCommand: "create",
+						Fields:      json.RawMessage(fields),
+						Parent:      fileUploadId,
+					}
+					segmentBuffer = append(segmentBuffer, segmentDoc)
+					segmentUploads = append(segmentUploads, uploadId)
+					bufferedBytes += docSize(segmentDoc)
 				}
-				segmentBuffer = append(segmentBuffer, segmentDoc)
-				segment.Subject = ""
 				if segmentFile, ok := fileBuffer[fileUploadId]; ok {
 					segmentFile.Segments = append(segmentFile.Segments, segment)
 					ad := true
 					for _, g := range segmentFile.Group {
-						if article.Group == g {
+						if a.Group == g {
 							ad = false
 						}
 					}
 					if ad {
-						segmentFile.Group = append(segmentFile.Group, article.Group)
+						segmentFile.Group = append(segmentFile.Group, a.Group)
 					}
 					segmentFile.ParentId = uploadId
 					fileBuffer[fileUploadId] = segmentFile
 				} else {
-					segmentFile = createFile(article)
+					segmentFile = createFile(a)
 					segmentFile.ParentId = uploadId
 					segmentFile.Segments = append(segmentFile.Segments, segment)
 					fileBuffer[fileUploadId] = segmentFile
+					if es.flushBytes > 0 {
+						bufferedBytes += es.fileDocSize(segmentFile)
+					}
 				}
 
 				if segmentUpload, ok := uploadBuffer[uploadId]; ok {
-					segmentUpload.Segments = append(segmentUpload.Segments, segment)
 					ad := true
 					for _, g := range segmentUpload.Group {
-						if article.Group == g {
+						if a.Group == g {
 							ad = false
 						}
 					}
 					if ad {
-						segmentUpload.Group = append(segmentUpload.Group, article.Group)
+						segmentUpload.Group = append(segmentUpload.Group, a.Group)
 					}
 					uploadBuffer[uploadId] = segmentUpload
 				} else {
-					segmentUpload = createUpload(article)
-					segmentUpload.Segments = append(segmentUpload.Segments, segment)
+					segmentUpload = createUpload(a)
 					uploadBuffer[uploadId] = segmentUpload
+					if es.flushBytes > 0 {
+						bufferedBytes += es.uploadDocSize(segmentUpload)
+					}
 				}
 
-				if es.docBuffSize > 0 {
-					if articleCount >= bfSz {
-						flushDocuments()
+				completed := false
+				if es.flushComplete {
+					p, ok := progress[uploadId]
+					if !ok {
+						st, _ := es.cachedState(uploadId)
+						p = newBufferedProgress(st, a.parts.Files)
+						progress[uploadId] = p
 					}
+					completed = p.add(fileUploadId, segment.Part, segment.Length)
+				}
+
+				switch {
+				case es.docBuffSize > 0 && articleCount >= bfSz:
+					flushDocuments()
+				case es.flushBytes > 0 && bufferedBytes >= es.flushBytes:
+					flushDocuments()
+				case completed:
+					es.logger.Printf("Upload %s is complete, flushing.", uploadId)
+					flushDocuments()
 				}
 			}
 		}
 	}
 }
 
+// uploadIndex returns the index of an upload. Files and segments are written
+// to the index of their upload, which is named after the post date of the
+// first article of the upload the sink sees, so an upload posted at the end
+// of a month isn't split between two indices. Until the state of an upload
+// is loaded, its index is a guess from date, uploadStates finds the index
+// the cluster has it in.
+func (es *ElasticSink) uploadIndex(uploadId string, date time.Time) string {
+	if st, _ := es.cachedState(uploadId); st != nil {
+		return st.index
+	}
+	return es.index.name(date)
+}
+
+// cachedState returns the state of an upload from the state cache, or from
+// the state database, and where it was found. It returns nil if the state
+// isn't in either.
+func (es *ElasticSink) cachedState(uploadId string) (*uploadState, string) {
+	es.statesLock.Lock()
+	st, ok := es.states.Get(uploadId)
+	es.statesLock.Unlock()
+	if ok {
+		return st.(*uploadState), "hit"
+	}
+	if es.stateDB == nil {
+		return nil, "miss"
+	}
+	saved, err := es.stateDB.get(uploadId)
+	if err != nil {
+		es.logger.Printf("Error: Failed to read the state of upload %s. (%s)", uploadId, err.Error())
+	}
+	if saved == nil {
+		return nil, "miss"
+	}
+	es.statesLock.Lock()
+	es.states.Add(uploadId, saved)
+	es.statesLock.Unlock()
+	return saved, "disk"
+}
+
+// uploadStates returns the states of the buffered uploads, from the state
+// cache, the state database or the cluster, uploads the cluster doesn't have start from the
+// buffered ones. Uploads whose state couldn't be loaded are left out, and
+// merged at the next flush.
+func (es *ElasticSink) uploadStates(uploads map[string]Upload, indices map[string]string) map[string]*uploadState {
+	states := make(map[string]*uploadState, len(uploads))
+	missing := make(map[string][]string)
+	for id := range uploads {
+		st, result := es.cachedState(id)
+		if st != nil {
+			states[id] = st
+		} else {
+			missing[indices[id]] = append(missing[indices[id]], id)
+		}
+		stateCache.WithLabelValues(es.instance, result).Inc()
+	}
+
+	load := func(id string, index string, source json.RawMessage) {
+		var u Upload
+		if source != nil {
+			if err := json.Unmarshal(source, &u); err != nil {
+				es.logger.Printf("Error: Failed to decode upload %s. (%s)", id, err.Error())
+				return
+			}
+			u.Id = id
+		} else {
+			u = uploads[id]
+			u.Date = time.Time{}
+			u.Group = nil
+		}
+		states[id] = newUploadState(index, u)
+	}
+	if es.index.timeBased() && len(missing) > 0 {
+		// The index of an upload the cluster has may be older than the post
+		// date of its buffered articles, if it was posted at the end of a
+		// month, so it is searched across the indices.
+		var ids []string
+		for _, batch := range missing {
+			ids = append(ids, batch...)
+		}
+		hits, err := es.client.Find(ids)
+		if err != nil {
+			es.logger.Printf("Error: Failed to find %d uploads in %s. (%s)", len(ids), es.alias, err.Error())
+			ids = nil
+		}
+		found := make(map[string]searchHit, len(hits))
+		for _, hit := range hits {
+			// An upload split between two indices is merged in the oldest.
+			if h, ok := found[hit.Id]; !ok || hit.Index < h.Index {
+				found[hit.Id] = hit
+			}
+		}
+		for _, id := range ids {
+			if hit, ok := found[id]; ok {
+				load(id, hit.Index, hit.Source)
+			} else {
+				load(id, indices[id], nil)
+			}
+		}
+		missing = nil
+	}
+	for index, ids := range missing {
+		sources, err := es.client.Get(index, "upload", ids, nil)
+		if err != nil {
+			es.logger.Printf("Error: Failed to load %d uploads from %s. (%s)", len(ids), index, err.Error())
+			continue
+		}
+		for _, id := range ids {
+			load(id, index, sources[id])
+		}
+	}
+
+	es.statesLock.Lock()
+	for id, st := range states {
+		es.states.Add(id, st)
+	}
+	es.statesLock.Unlock()
+	return states
+}
+
 func (es *ElasticSink) Serve() {
-	es.logger.Printf("Starting ElasticSink, writing data to http://%s:%d", es.host, es.port)
-	es.esConn = goes.NewConnection(es.host, es.port)
-	es.articles = make(chan newsrover.Article)
+	es.logger.Printf("Starting ElasticSink, writing data to %s at %s", es.name, es.http)
+	if es.name == osSINK_NAME {
+		es.client = newOpensearchClient(es.http, es.index, es.alias, es.bootstrap)
+	} else {
+		es.client = newLegacyClient(es.http, es.index, es.alias, es.bootstrap)
+	}
+	if err := es.client.Setup(); err != nil {
+		if es.bootstrap != "" {
+			es.logger.Printf("Error: Failed to set up %s, refusing to start. %s", es.name, err.Error())
+			es.client = nil
+			es.setupErr = err
+			return
+		}
+		es.logger.Printf("Error: Failed to set up %s. %s", es.name, err.Error())
+	}
+	if es.stateDBPath != "" {
+		if db, err := openStateDB(es.stateDBPath, es.stateDBAge); err == nil {
+			es.stateDB = db
+			n, err := db.seed(es.states.MaxEntries, func(id string, st *uploadState) {
+				es.states.Add(id, st)
+			})
+			if err != nil {
+				es.logger.Printf("Error: Failed to seed the state cache from %s. (%s)", es.stateDBPath, err.Error())
+			}
+			es.logger.Printf("Seeded the state cache with %d uploads.", n)
+		} else {
+			es.logger.Printf("Error: Failed to open the state database %s. (%s)", es.stateDBPath, err.Error())
+		}
+	}
 	es.stop = make(chan bool)
-	control := make(chan bool)
+	articles := make([]chan article, es.workers)
+	es.marks = make([]chan dmcaMark, es.workers)
 	var wg sync.WaitGroup
-	for i := 0; i < es.workers; i++ {
+	for i := range articles {
+		articles[i] = make(chan article, es.queueSize/es.workers)
+		es.marks[i] = make(chan dmcaMark, 16)
 		wg.Add(1)
-		go func() {
+		go func(articles <-chan article, marks <-chan dmcaMark) {
 			defer wg.Done()
-			es.serve(control)
+			es.serve(articles, marks)
+		}(articles[i], es.marks[i])
+	}
+	if es.walDir != "" {
+		if w, err := openWal(es.walDir, es.walSync); err == nil {
+			es.wal = w
+		} else {
+			es.logger.Printf("Error: Failed to open the write ahead log %s. (%s)", es.walDir, err.Error())
+		}
+	}
+	if es.spoolPath != "" {
+		if s, err := openSpool(es.spoolPath); err == nil {
+			es.spool = s
+			es.spilledUploads = make(map[string]int)
+			err := s.each(func(a newsrover.Article) {
+				if art, ok := es.prepare(a); ok {
+					es.spilledUploads[art.uploadId]++
+				}
+			})
+			if err != nil {
+				es.logger.Printf("Error: Failed to read spill file %s. (%s)", es.spoolPath, err.Error())
+			}
+		} else {
+			es.logger.Printf("Error: Failed to open spill file %s, dropping articles on overflow. (%s)", es.spoolPath, err.Error())
+			es.overflow = overflowDrop
+		}
+	}
+	es.articlesLock.Lock()
+	es.stopping = make(chan bool)
+	es.articles = articles
+	es.articlesLock.Unlock()
+
+	if w := es.wal; w != nil {
+		n := 0
+		err := w.recover(func(a newsrover.Article, seg *walSegment) bool {
+			art, ok := es.prepare(a)
+			if !ok {
+				return false
+			}
+			art.wal = seg
+			articles[es.partition(art.uploadId)] <- art
+			n++
+			return true
+		})
+		if err != nil {
+			es.logger.Printf("Error: Failed to recover the write ahead log %s. (%s)", es.walDir, err.Error())
+		} else if n > 0 {
+			es.logger.Printf("Recovered %d articles from the write ahead log.", n)
+		}
+	}
+
+	drained := make(chan bool)
+	if es.spool != nil {
+		go func() {
+			defer close(drained)
+			es.drain(es.stopping)
 		}()
+	} else {
+		close(drained)
+	}
+	dmcaStop := make(chan bool)
+	dmcaDone := make(chan bool)
+	if es.dmca != nil {
+		go func() {
+			defer close(dmcaDone)
+			es.checkDmca(dmcaStop)
+		}()
+	} else {
+		close(dmcaDone)
+	}
+	if !es.replay {
+		publishMetrics(es)
 	}
+
 	select {
 	case <-es.stop:
+		close(es.stopping)
+		<-drained
+		close(dmcaStop)
+		<-dmcaDone
+		// Workers flush the articles left in their queue before returning.
 		es.articlesLock.Lock()
-		close(es.articles)
+		es.senders.Wait()
+		for _, c := range es.articles {
+			close(c)
+		}
 		es.articles = nil
 		es.articlesLock.Unlock()
-		close(control)
 		es.stop = nil
 	}
 	wg.Wait()
-	es.esConn = nil
+	unpublishMetrics(es)
+	if es.stateDB != nil {
+		es.stateDB.Close()
+		es.stateDB = nil
+	}
+	if es.wal != nil {
+		es.wal.Close()
+		es.wal = nil
+	}
+	if es.spool != nil {
+		if err := es.spool.Close(); err != nil {
+			es.logger.Printf("Error: Failed to compact spill file %s. (%s)", es.spoolPath, err.Error())
+		}
+		es.spool = nil
+	}
+	if es.deadLetterFile != nil {
+		es.failLogLock.Lock()
+		if err := es.deadLetterFile.Close(); err != nil {
+			es.logger.Printf("Error: Failed to close the dead letter queue %s. (%s)", es.deadLetterFile.Name(), err.Error())
+		}
+		es.deadLetters = ioutil.Discard
+		es.deadLetterFile = nil
+		es.failLogLock.Unlock()
+	}
+	es.marks = nil
+	es.client = nil
 }
 
 func (es *ElasticSink) Stop() {
@@ -0,0 +1,249 @@
+package elasticsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/animezb/newsrover"
+	"github.com/golang/groupcache/lru"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewElasticSinkRetries(t *testing.T) {
+	for _, c := range []struct {
+		config  string
+		retries int
+	}{
+		{`{}`, 5},
+		{`{"retries": 0}`, 0},
+		{`{"retries": 2}`, 2},
+	} {
+		var params ElasticSinkParams
+		if err := json.Unmarshal([]byte(c.config), &params); err != nil {
+			t.Fatal(err)
+		}
+		es, err := NewElasticSink(params)
+		if err != nil {
+			t.Errorf("%s: %s", c.config, err)
+		} else if es.retries != c.retries {
+			t.Errorf("%s: %d retries, want %d", c.config, es.retries, c.retries)
+		}
+	}
+	retries := -1
+	if _, err := NewElasticSink(ElasticSinkParams{Retries: &retries}); err == nil {
+		t.Error("Negative retries accepted")
+	}
+}
+
+func TestNewElasticSinkQueueSize(t *testing.T) {
+	if _, err := NewElasticSink(ElasticSinkParams{Workers: 4, QueueSize: 3}); err == nil {
+		t.Error("Queue smaller than the workers accepted")
+	}
+	es, err := NewElasticSink(ElasticSinkParams{Workers: 4, QueueSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if es.queueSize/es.workers != 1 {
+		t.Errorf("Worker queues of %d articles, want 1", es.queueSize/es.workers)
+	}
+}
+
+func TestPrepareObfuscated(t *testing.T) {
+	es := &ElasticSink{obfuscatedWindow: time.Hour}
+	for _, c := range []struct {
+		group      string
+		subject    string
+		obfuscated bool
+		release    string
+	}{
+		// No rule matches, the post is filed under its poster.
+		{"alt.binaries.anime", `a1b2c3d4e5f6a7b8c9d0e1f2 [01/10] - "Kx9fQ2mZ7pL4wR8t.part01.rar" yEnc (1/50)`, true, ""},
+		// A rule matches, the release it extracted is kept.
+		{"alt.binaries.anime", `([Kx9fQ2mZ7pL4wR8t] a1b2c3d4e5f6a7b8c9d0) [01/10] - "a1b2c3d4e5f6a7b8c9d0.part01.rar" yEnc (1/50)`, false, "[Kx9fQ2mZ7pL4wR8t] a1b2c3d4e5f6a7b8c9d0"},
+		// The fallback rules of a group without rules match the hash.
+		{"alt.binaries.teevee", `"d41d8cd98f00b204e9800998ecf8427e.part01.rar" yEnc (3/100)`, true, ""},
+		{"alt.binaries.teevee", `"Some.Show.S01E05.720p.HDTV.x264-GRP.part01.rar" yEnc (3/100)`, false, "Some.Show.S01E05.720p.HDTV.x264-GRP"},
+	} {
+		art, ok := es.prepare(newsrover.Article{Group: c.group, From: "poster", Subject: c.subject})
+		if !ok {
+			t.Errorf("%s: not indexed", c.subject)
+			continue
+		}
+		if art.obfuscated != c.obfuscated {
+			t.Errorf("%s: obfuscated is %v, want %v", c.subject, art.obfuscated, c.obfuscated)
+		}
+		if !c.obfuscated && art.release != c.release {
+			t.Errorf("%s: release %q, want %q", c.subject, art.release, c.release)
+		}
+	}
+}
+
+func TestNewElasticSinkReplay(t *testing.T) {
+	var params ElasticSinkParams
+	config := `{"replay": true, "wal": "wal", "overflow": "spill", "spill": "spill.log", "state_db": "states.db", "dmca": {"host": "news.test:119"}}`
+	if err := json.Unmarshal([]byte(config), &params); err != nil {
+		t.Fatal(err)
+	}
+	es, err := NewElasticSink(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if es.walDir != "" || es.overflow != overflowBlock || es.spoolPath != "" || es.stateDBPath != "" || es.dmca != nil {
+		t.Errorf("Replay uses the wal %q, overflow %s to %q, state database %q and takedown checks %v", es.walDir, es.overflow, es.spoolPath, es.stateDBPath, es.dmca)
+	}
+}
+
+func TestUploadStatesAcrossIndices(t *testing.T) {
+	var searched string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		searched = r.URL.Path + " " + string(body)
+		fmt.Fprint(w, `{"hits": {"hits": [
+			{"_index": "nzb-2024.02-upload", "_id": "a", "_source": {"subject": "split"}},
+			{"_index": "nzb-2024.01-upload", "_id": "a", "_source": {"subject": "first"}}
+		]}}`)
+	}))
+	defer server.Close()
+	client, _ := newHttpClient(httpConfig{nodes: []string{server.URL}})
+	index, _ := parseIndexTemplate("nzb-{yyyy.MM}")
+	es := &ElasticSink{
+		name:   osSINK_NAME,
+		index:  index,
+		alias:  "nzb",
+		client: newOpensearchClient(client, index, "nzb", ""),
+		states: lru.New(10),
+		logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	// The later segments of a, posted in February, and a new upload b.
+	states := es.uploadStates(
+		map[string]Upload{"a": {Id: "a"}, "b": {Id: "b", Subject: "new"}},
+		map[string]string{"a": "nzb-2024.02", "b": "nzb-2024.02"})
+	if searched != `/nzb-upload/_search {"query":{"ids":{"values":["a","b"]}},"size":2}` &&
+		searched != `/nzb-upload/_search {"query":{"ids":{"values":["b","a"]}},"size":2}` {
+		t.Errorf("Searched %s", searched)
+	}
+	if st := states["a"]; st == nil || st.index != "nzb-2024.01" || st.upload.Subject != "first" {
+		t.Errorf("State of a is %+v, want the one of nzb-2024.01", st)
+	}
+	if st := states["b"]; st == nil || st.index != "nzb-2024.02" || st.upload.Subject != "new" {
+		t.Errorf("State of b is %+v, want the buffered one", st)
+	}
+	if index := es.uploadIndex("a", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)); index != "nzb-2024.01" {
+		t.Errorf("Index of a is %s after loading its state", index)
+	}
+}
+
+func TestNewElasticSinkSpill(t *testing.T) {
+	if _, err := NewElasticSink(ElasticSinkParams{Overflow: overflowSpill}); err == nil {
+		t.Error("Spill policy accepted without a spill file")
+	}
+	es, err := NewElasticSink(ElasticSinkParams{Overflow: overflowSpill, Wal: "wal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if es.spoolPath != filepath.Join("wal", "spill.log") {
+		t.Errorf("Spill file is %s, want it in the wal directory", es.spoolPath)
+	}
+}
+
+// testArticles returns articles of the same upload.
+func testArticles(n int) []newsrover.Article {
+	articles := make([]newsrover.Article, n)
+	for i := range articles {
+		articles[i] = newsrover.Article{
+			Group:     "alt.binaries.anime",
+			Subject:   fmt.Sprintf(`[AST] One Piece - [007/340] - "One Piece episode 301-350.part006.rar" yEnc (%d/10)`, i+1),
+			MessageId: fmt.Sprintf("<%d@test>", i),
+		}
+	}
+	return articles
+}
+
+func TestAcceptSpillOrder(t *testing.T) {
+	s, err := openSpool(filepath.Join(t.TempDir(), "spill.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	queue := make(chan article, 1)
+	es := &ElasticSink{
+		articles:       []chan article{queue},
+		overflow:       overflowSpill,
+		spool:          s,
+		spilledUploads: make(map[string]int),
+		logger:         log.New(ioutil.Discard, "", 0),
+	}
+	es.Accept(testArticles(2))
+	<-queue
+	// Room in the queue, but the upload has a spilled article.
+	es.Accept(testArticles(3)[2:])
+	if len(queue) != 0 || s.Len() != 2 {
+		t.Fatalf("%d articles queued and %d spilled, want 0 and 2", len(queue), s.Len())
+	}
+
+	stop := make(chan bool)
+	drained := make(chan bool)
+	go func() {
+		defer close(drained)
+		es.drain(stop)
+	}()
+	for i := 1; i < 3; i++ {
+		if a := <-queue; a.MessageId != fmt.Sprintf("<%d@test>", i) {
+			t.Errorf("Got %s, want <%d@test>", a.MessageId, i)
+		}
+	}
+	close(stop)
+	<-drained
+	if len(es.spilledUploads) != 0 {
+		t.Errorf("Spilled uploads left %v", es.spilledUploads)
+	}
+}
+
+func TestAcceptBlockStop(t *testing.T) {
+	var failed bytes.Buffer
+	queue := make(chan article, 1)
+	es := &ElasticSink{
+		articles: []chan article{queue},
+		overflow: overflowBlock,
+		stopping: make(chan bool),
+		failLog:  &failed,
+		logger:   log.New(ioutil.Discard, "", 0),
+	}
+	accepted := make(chan bool)
+	go func() {
+		defer close(accepted)
+		es.Accept(testArticles(3))
+	}()
+	// Stopping takes the lock while Accept waits for room.
+	time.Sleep(10 * time.Millisecond)
+	close(es.stopping)
+	es.articlesLock.Lock()
+	es.senders.Wait()
+	es.articlesLock.Unlock()
+	<-accepted
+	if n := bytes.Count(failed.Bytes(), []byte("@test")); n != 2 {
+		t.Errorf("%d articles failed, want the 2 waiting for room", n)
+	}
+}
+
+func TestNewElasticSinkInvalidOpensNothing(t *testing.T) {
+	dir := t.TempDir()
+	params := ElasticSinkParams{
+		FailLogPath: filepath.Join(dir, "fail.log"),
+		DeadLetter:  filepath.Join(dir, "dead.log"),
+		Segments:    "nope",
+	}
+	if _, err := NewElasticSink(params); err == nil {
+		t.Fatal("Unknown segments mode accepted")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Invalid sink opened %d files", len(files))
+	}
+}
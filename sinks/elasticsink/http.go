@@ -0,0 +1,227 @@
+package elasticsink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// httpConfig is how the sink connects to the cluster.
+type httpConfig struct {
+	// nodes are the URLs of the nodes, http://host:port or
+	// https://host:port.
+	nodes []string
+	// ca is the PEM file of the certificate authorities trusted for https,
+	// the system ones are used if it is empty.
+	ca       string
+	username string
+	password string
+	apiKey   string
+	timeout  time.Duration
+}
+
+// httpClient sends JSON requests to a cluster. Requests go to the nodes in
+// turn, and to the next node when one can't be reached.
+type httpClient struct {
+	nodes  []string
+	next   uint32
+	auth   string
+	client *http.Client
+}
+
+func newHttpClient(conf httpConfig) (*httpClient, error) {
+	if len(conf.nodes) == 0 {
+		return nil, fmt.Errorf("No nodes.")
+	}
+	c := &httpClient{client: &http.Client{Timeout: conf.timeout}}
+	if c.client.Timeout <= 0 {
+		c.client.Timeout = time.Minute
+	}
+	for _, node := range conf.nodes {
+		node = strings.TrimRight(node, "/")
+		if !strings.HasPrefix(node, "http://") && !strings.HasPrefix(node, "https://") {
+			return nil, fmt.Errorf("Node %s is not an http:// or https:// URL.", node)
+		}
+		c.nodes = append(c.nodes, node)
+	}
+	if conf.ca != "" {
+		pem, err := ioutil.ReadFile(conf.ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates in %s.", conf.ca)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		c.client.Transport = transport
+	}
+	switch {
+	case conf.apiKey != "" && conf.username != "":
+		return nil, fmt.Errorf("Both an API key and a username are set.")
+	case conf.apiKey != "":
+		c.auth = "ApiKey " + conf.apiKey
+	case conf.username != "":
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(conf.username, conf.password)
+		c.auth = req.Header.Get("Authorization")
+	}
+	return c, nil
+}
+
+// notFoundError is returned for the 404 responses of the cluster.
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+
+// send sends a request to the nodes in turn, starting with the next one, until
+// one of them answers.
+func (c *httpClient) send(method, path string, contentType string, body []byte) (*http.Response, error) {
+	start := atomic.AddUint32(&c.next, 1)
+	var err error
+	for i := 0; i < len(c.nodes); i++ {
+		node := c.nodes[(int(start)+i)%len(c.nodes)]
+		var req *http.Request
+		if req, err = http.NewRequest(method, node+path, bytes.NewReader(body)); err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		var resp *http.Response
+		if resp, err = c.client.Do(req); err == nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+func (c *httpClient) do(method, path string, contentType string, body io.Reader, v interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = ioutil.ReadAll(body); err != nil {
+			return err
+		}
+	}
+	resp, err := c.send(method, path, contentType, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		err := fmt.Sprintf("%s %s returned %s. (%s)", method, path, resp.Status, bytes.TrimSpace(msg))
+		if resp.StatusCode == http.StatusNotFound {
+			return notFoundError(err)
+		}
+		return fmt.Errorf("%s", err)
+	}
+	if v == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *httpClient) json(method, path string, body interface{}, v interface{}) error {
+	if body == nil {
+		return c.do(method, path, "application/json", nil, v)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return c.do(method, path, "application/json", bytes.NewReader(data), v)
+}
+
+func (c *httpClient) exists(path string) (bool, error) {
+	err := c.do("HEAD", path, "application/json", nil, nil)
+	if _, ok := err.(notFoundError); ok {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// mget returns the sources of the documents found with the multi get API at
+// path, routed by routing if it has their id. The documents of an index that
+// doesn't exist are not found.
+func (c *httpClient) mget(path string, ids []string, routing map[string]string) (map[string]json.RawMessage, error) {
+	var r struct {
+		Docs []struct {
+			Id     string          `json:"_id"`
+			Found  bool            `json:"found"`
+			Source json.RawMessage `json:"_source"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"docs"`
+	}
+	var body interface{} = map[string][]string{"ids": ids}
+	if len(routing) > 0 {
+		docs := make([]map[string]string, len(ids))
+		for i, id := range ids {
+			docs[i] = map[string]string{"_id": id}
+			if r, ok := routing[id]; ok {
+				docs[i]["_routing"] = r
+			}
+		}
+		body = map[string]interface{}{"docs": docs}
+	}
+	err := c.json("POST", path, body, &r)
+	if _, ok := err.(notFoundError); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sources := make(map[string]json.RawMessage, len(r.Docs))
+	for _, doc := range r.Docs {
+		if len(doc.Error) > 0 {
+			msg := string(doc.Error)
+			if strings.Contains(msg, "IndexMissingException") || strings.Contains(msg, "index_not_found_exception") || strings.HasSuffix(msg, `] missing"`) {
+				continue
+			}
+			return nil, fmt.Errorf("Failed to get %s. (%s)", doc.Id, msg)
+		}
+		if doc.Found {
+			sources[doc.Id] = doc.Source
+		}
+	}
+	return sources, nil
+}
+
+// searchHit is a document found by a search.
+type searchHit struct {
+	Index  string          `json:"_index"`
+	Id     string          `json:"_id"`
+	Source json.RawMessage `json:"_source"`
+}
+
+// search returns the documents found with the search API at path. An index
+// that doesn't exist has no documents.
+func (c *httpClient) search(path string, query interface{}) ([]searchHit, error) {
+	var r struct {
+		Hits struct {
+			Hits []searchHit `json:"hits"`
+		} `json:"hits"`
+	}
+	err := c.json("POST", path, query, &r)
+	if _, ok := err.(notFoundError); ok {
+		return nil, nil
+	}
+	return r.Hits.Hits, err
+}
+
+func (c *httpClient) String() string {
+	return strings.Join(c.nodes, ", ")
+}
@@ -0,0 +1,80 @@
+package elasticsink
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHttpClientFailover(t *testing.T) {
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	c, err := newHttpClient(httpConfig{nodes: []string{down.URL, server.URL + "/"}, apiKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := c.json("GET", "/", nil, nil); err != nil {
+			t.Errorf("Request %d failed. %s", i, err)
+		}
+	}
+	if len(auth) != 4 || auth[0] != "ApiKey key" {
+		t.Errorf("Requests sent with %v", auth)
+	}
+
+	c, _ = newHttpClient(httpConfig{nodes: []string{server.URL}, username: "rover", password: "secret"})
+	c.json("GET", "/", nil, nil)
+	if auth[4] != "Basic cm92ZXI6c2VjcmV0" {
+		t.Errorf("Basic auth sent as %s", auth[4])
+	}
+
+	c, _ = newHttpClient(httpConfig{nodes: []string{down.URL}})
+	if err := c.json("GET", "/", nil, nil); err == nil {
+		t.Errorf("Expected an error without nodes up")
+	}
+	for _, conf := range []httpConfig{{}, {nodes: []string{"localhost:9200"}}, {nodes: []string{server.URL}, apiKey: "key", username: "rover"}} {
+		if _, err := newHttpClient(conf); err == nil {
+			t.Errorf("Expected an error for %+v", conf)
+		}
+	}
+}
+
+func TestHttpClientCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "elasticsink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, cert, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := newHttpClient(httpConfig{nodes: []string{server.URL}})
+	if err := c.json("GET", "/", nil, nil); err == nil {
+		t.Errorf("Expected an error without the CA")
+	}
+	c, err = newHttpClient(httpConfig{nodes: []string{server.URL}, ca: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.json("GET", "/", nil, nil); err != nil {
+		t.Errorf("Request failed with the CA. %s", err)
+	}
+}
@@ -0,0 +1,142 @@
+package elasticsink
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// indexTemplate is the name of the index of the sink. The parts of the name
+// in braces are dates, written with the Joda letters ElasticSearch uses
+// (yyyy, yy, MM, dd and HH), and formatted with the post date of an upload:
+// nzb-{yyyy.MM} names an index per month.
+type indexTemplate struct {
+	template string
+	parts    []indexPart
+}
+
+// indexPart is a literal part of an index name, or a date layout.
+type indexPart struct {
+	literal string
+	layout  string
+}
+
+var indexDateLetters = map[string]string{
+	"yyyy": "2006",
+	"yy":   "06",
+	"MM":   "01",
+	"dd":   "02",
+	"HH":   "15",
+}
+
+func parseIndexTemplate(template string) (indexTemplate, error) {
+	t := indexTemplate{template: template}
+	rest := template
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, indexPart{literal: rest})
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, indexPart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return t, fmt.Errorf("Index name %s has an unclosed {.", template)
+		}
+		layout, err := indexDateLayout(rest[open+1 : open+end])
+		if err != nil {
+			return t, fmt.Errorf("Index name %s is invalid. %s", template, err.Error())
+		}
+		t.parts = append(t.parts, indexPart{layout: layout})
+		rest = rest[open+end+1:]
+	}
+	if strings.ContainsAny(t.pattern(), "}\"\\/ ,#:?<>|") || t.pattern() != strings.ToLower(t.pattern()) {
+		return t, fmt.Errorf("Index name %s is invalid.", template)
+	}
+	return t, nil
+}
+
+// indexDateLayout converts a Joda date pattern to a time layout.
+func indexDateLayout(pattern string) (string, error) {
+	if pattern == "" {
+		return "", fmt.Errorf("Empty date pattern.")
+	}
+	layout := ""
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			if !strings.ContainsRune(".-_", rune(c)) {
+				return "", fmt.Errorf("Unexpected %q in date pattern %s.", c, pattern)
+			}
+			layout += string(c)
+			i++
+			continue
+		}
+		j := i
+		for j < len(pattern) && pattern[j] == c {
+			j++
+		}
+		letters, ok := indexDateLetters[pattern[i:j]]
+		if !ok {
+			return "", fmt.Errorf("Unknown date pattern %s.", pattern[i:j])
+		}
+		layout += letters
+		i = j
+	}
+	return layout, nil
+}
+
+// name returns the name of the index for an upload posted at date.
+func (t indexTemplate) name(date time.Time) string {
+	name := ""
+	for _, p := range t.parts {
+		if p.layout == "" {
+			name += p.literal
+		} else {
+			name += date.UTC().Format(p.layout)
+		}
+	}
+	return name
+}
+
+// timeBased returns true if the name has a date in it.
+func (t indexTemplate) timeBased() bool {
+	for _, p := range t.parts {
+		if p.layout != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// pattern returns the wildcard pattern of every index of the template.
+func (t indexTemplate) pattern() string {
+	pattern := ""
+	for _, p := range t.parts {
+		if p.layout == "" {
+			pattern += p.literal
+		} else if !strings.HasSuffix(pattern, "*") {
+			pattern += "*"
+		}
+	}
+	return pattern
+}
+
+// alias returns the default alias of the indices, the name up to the first
+// date without its trailing separators.
+func (t indexTemplate) alias() string {
+	alias := ""
+	for _, p := range t.parts {
+		if p.layout != "" {
+			break
+		}
+		alias += p.literal
+	}
+	return strings.TrimRight(alias, ".-_")
+}
+
+func (t indexTemplate) String() string {
+	return t.template
+}
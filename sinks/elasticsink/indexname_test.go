@@ -0,0 +1,46 @@
+package elasticsink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIndexTemplate(t *testing.T) {
+	date := time.Date(2015, time.March, 7, 23, 0, 0, 0, time.FixedZone("", -2*3600))
+	tests := []struct {
+		template  string
+		name      string
+		pattern   string
+		alias     string
+		timeBased bool
+	}{
+		{"nzb", "nzb", "nzb", "nzb", false},
+		{"nzb-{yyyy.MM}", "nzb-2015.03", "nzb-*", "nzb", true},
+		{"nzb-{yy}.{MM}.{dd}", "nzb-15.03.08", "nzb-*.*.*", "nzb", true},
+		{"usenet_nzb-{yyyy}-v2", "usenet_nzb-2015-v2", "usenet_nzb-*-v2", "usenet_nzb", true},
+	}
+	for _, test := range tests {
+		index, err := parseIndexTemplate(test.template)
+		if err != nil {
+			t.Errorf("%s: %s", test.template, err)
+			continue
+		}
+		if name := index.name(date); name != test.name {
+			t.Errorf("%s: name is %s, expected %s", test.template, name, test.name)
+		}
+		if pattern := index.pattern(); pattern != test.pattern {
+			t.Errorf("%s: pattern is %s, expected %s", test.template, pattern, test.pattern)
+		}
+		if alias := index.alias(); alias != test.alias {
+			t.Errorf("%s: alias is %s, expected %s", test.template, alias, test.alias)
+		}
+		if index.timeBased() != test.timeBased {
+			t.Errorf("%s: timeBased is %v", test.template, index.timeBased())
+		}
+	}
+	for _, template := range []string{"nzb-{yyyy.MM", "nzb-{}", "nzb-{yyyy.mm}", "nzb-{MMM}", "NZB-{yyyy}", "nzb/{yyyy}"} {
+		if _, err := parseIndexTemplate(template); err == nil {
+			t.Errorf("%s: expected an error", template)
+		}
+	}
+}
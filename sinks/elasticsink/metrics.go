@@ -0,0 +1,128 @@
+package elasticsink
+
+import (
+	"github.com/animezb/goes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	acceptedArticles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "newsroverd_sink_articles_accepted_total",
+		Help: "Articles passed to the sink.",
+	}, []string{"sink"})
+	filteredArticles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "newsroverd_sink_articles_filtered_total",
+		Help: "Articles the sink doesn't index, as they aren't yEnc or no release could be extracted from their subject.",
+	}, []string{"sink"})
+	indexedArticles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "newsroverd_sink_articles_indexed_total",
+		Help: "Articles whose segment was written to the cluster.",
+	}, []string{"sink"})
+	flushSizes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "newsroverd_sink_flush_documents",
+		Help:    "Documents per flush.",
+		Buckets: []float64{10, 50, 100, 500, 1000, 5000, 10000, 50000},
+	}, []string{"sink"})
+	bulkSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "newsroverd_sink_bulk_seconds",
+		Help:    "Latency of the bulk requests.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"sink"})
+	itemErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "newsroverd_sink_bulk_item_errors_total",
+		Help: "Documents of bulk requests that failed, by error.",
+	}, []string{"sink", "error"})
+	stateCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "newsroverd_sink_state_cache_total",
+		Help: "Lookups of the upload state cache, by result.",
+	}, []string{"sink", "result"})
+	dmcaChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "newsroverd_sink_dmca_checks_total",
+		Help: "Uploads checked for takedowns, by result.",
+	}, []string{"sink", "result"})
+)
+
+var (
+	bufferedArticles = prometheus.NewDesc("newsroverd_sink_buffered_articles",
+		"Articles buffered by the workers until the next flush.", []string{"sink"}, nil)
+	queueCapacity = prometheus.NewDesc("newsroverd_sink_queue_capacity",
+		"Capacity of the queues of the workers.", []string{"sink"}, nil)
+	queueDepth = prometheus.NewDesc("newsroverd_sink_queue_depth",
+		"Articles in the queues of the workers.", []string{"sink"}, nil)
+	spoolDepth = prometheus.NewDesc("newsroverd_sink_spool_depth",
+		"Articles in the spill file.", []string{"sink"}, nil)
+	overflowArticles = prometheus.NewDesc("newsroverd_sink_overflow_articles_total",
+		"Articles that overflowed the queues, by what the sink did with them.", []string{"sink", "policy"}, nil)
+)
+
+// sinkCollector collects the metrics read from the serving sinks.
+type sinkCollector struct {
+	lock  sync.Mutex
+	sinks map[string]*ElasticSink
+}
+
+var sinkMetrics = &sinkCollector{sinks: make(map[string]*ElasticSink)}
+
+func init() {
+	prometheus.MustRegister(sinkMetrics)
+}
+
+func (c *sinkCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bufferedArticles
+	ch <- queueCapacity
+	ch <- queueDepth
+	ch <- spoolDepth
+	ch <- overflowArticles
+}
+
+func (c *sinkCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for name, es := range c.sinks {
+		queued, spilled := es.QueueDepth()
+		ch <- prometheus.MustNewConstMetric(bufferedArticles, prometheus.GaugeValue, float64(atomic.LoadInt64(&es.buffered)), name)
+		ch <- prometheus.MustNewConstMetric(queueCapacity, prometheus.GaugeValue, float64(es.queueSize), name)
+		ch <- prometheus.MustNewConstMetric(queueDepth, prometheus.GaugeValue, float64(queued), name)
+		ch <- prometheus.MustNewConstMetric(spoolDepth, prometheus.GaugeValue, float64(spilled), name)
+		ch <- prometheus.MustNewConstMetric(overflowArticles, prometheus.CounterValue, float64(atomic.LoadInt64(&es.spilled)), name, overflowSpill)
+		ch <- prometheus.MustNewConstMetric(overflowArticles, prometheus.CounterValue, float64(atomic.LoadInt64(&es.dropped)), name, overflowDrop)
+	}
+}
+
+// publishMetrics collects the metrics read from the sink until
+// unpublishMetrics is called. Only one serving sink of an instance name is
+// collected.
+func publishMetrics(es *ElasticSink) {
+	sinkMetrics.lock.Lock()
+	defer sinkMetrics.lock.Unlock()
+	if other, ok := sinkMetrics.sinks[es.instance]; ok && other != es {
+		es.logger.Printf("Error: Another sink serves the metrics of %s, set instance to tell them apart.", es.instance)
+		return
+	}
+	sinkMetrics.sinks[es.instance] = es
+}
+
+func unpublishMetrics(es *ElasticSink) {
+	sinkMetrics.lock.Lock()
+	if sinkMetrics.sinks[es.instance] == es {
+		delete(sinkMetrics.sinks, es.instance)
+	}
+	sinkMetrics.lock.Unlock()
+}
+
+// countBulk counts the item errors of a bulk response, and the segments that
+// were written.
+func (es *ElasticSink) countBulk(docs []goes.Document, items []bulkItem) {
+	indexed := 0
+	for i, doc := range docs {
+		if i < len(items) && items[i].failed() && !items[i].exists(doc.BulkCommand) {
+			itemErrors.WithLabelValues(es.instance, items[i].errorType()).Inc()
+		} else if doc.Type == "segment" {
+			indexed++
+		}
+	}
+	indexedArticles.WithLabelValues(es.instance).Add(float64(indexed))
+}
@@ -0,0 +1,57 @@
+package elasticsink
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+func TestSinkMetrics(t *testing.T) {
+	r := prometheus.NewPedanticRegistry()
+	r.MustRegister(sinkMetrics)
+	es := &ElasticSink{instance: "test-metrics", queueSize: 64, buffered: 3, dropped: 2}
+	gather := func() map[string]float64 {
+		families, err := r.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := make(map[string]float64)
+		for _, f := range families {
+			for _, m := range f.GetMetric() {
+				name := f.GetName()
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "policy" {
+						name += "/" + l.GetValue()
+					}
+				}
+				if m.GetGauge() != nil {
+					values[name] = m.GetGauge().GetValue()
+				} else {
+					values[name] = m.GetCounter().GetValue()
+				}
+			}
+		}
+		return values
+	}
+
+	publishMetrics(es)
+	// A second sink of the same instance name isn't collected.
+	publishMetrics(&ElasticSink{instance: "test-metrics", queueSize: 8, logger: log.New(ioutil.Discard, "", 0)})
+	values := gather()
+	for name, want := range map[string]float64{
+		"newsroverd_sink_buffered_articles":             3,
+		"newsroverd_sink_queue_capacity":                64,
+		"newsroverd_sink_queue_depth":                   0,
+		"newsroverd_sink_overflow_articles_total/drop":  2,
+		"newsroverd_sink_overflow_articles_total/spill": 0,
+	} {
+		if got, ok := values[name]; !ok || got != want {
+			t.Errorf("%s is %v, want %v", name, got, want)
+		}
+	}
+	unpublishMetrics(es)
+	if values := gather(); len(values) != 0 {
+		t.Errorf("Metrics of an unpublished sink: %v", values)
+	}
+}
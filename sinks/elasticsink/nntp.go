@@ -0,0 +1,87 @@
+package elasticsink
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// nntpConn is a connection to a news server, enough of NNTP to ask it
+// whether it has articles.
+type nntpConn struct {
+	conn net.Conn
+	text *textproto.Conn
+}
+
+func dialNNTP(host string, ssl bool, user, pass string, timeout time.Duration) (*nntpConn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if ssl {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &nntpConn{conn: conn, text: textproto.NewConn(conn)}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, _, err := c.text.ReadCodeLine(20); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if user != "" {
+		code, msg, err := c.cmd(fmt.Sprintf("AUTHINFO USER %s", user))
+		if err == nil && code == 381 {
+			code, msg, err = c.cmd(fmt.Sprintf("AUTHINFO PASS %s", pass))
+		}
+		if err == nil && code != 281 {
+			err = fmt.Errorf("Authentication failed. (%d %s)", code, msg)
+		}
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *nntpConn) cmd(line string) (int, string, error) {
+	id, err := c.text.Cmd("%s", line)
+	if err != nil {
+		return 0, "", err
+	}
+	c.text.StartResponse(id)
+	defer c.text.EndResponse(id)
+	return c.text.ReadCodeLine(0)
+}
+
+// stat returns true if the server has the article of a message id.
+func (c *nntpConn) stat(messageId string, timeout time.Duration) (bool, error) {
+	if !strings.HasPrefix(messageId, "<") {
+		messageId = "<" + messageId + ">"
+	}
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+	code, msg, err := c.cmd("STAT " + messageId)
+	switch {
+	case err != nil:
+		return false, err
+	case code == 223:
+		return true, nil
+	case code == 430:
+		return false, nil
+	}
+	return false, fmt.Errorf("Unexpected response to STAT %s. (%d %s)", messageId, code, msg)
+}
+
+func (c *nntpConn) Close() error {
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	c.text.Cmd("QUIT")
+	return c.text.Close()
+}
@@ -0,0 +1,64 @@
+package elasticsink
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNNTPStat(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "200 news.test ready\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch line = strings.TrimSpace(line); {
+			case line == "AUTHINFO USER user":
+				fmt.Fprint(conn, "381 Password required\r\n")
+			case line == "AUTHINFO PASS pass":
+				fmt.Fprint(conn, "281 Ok\r\n")
+			case line == "STAT <there@test>":
+				fmt.Fprint(conn, "223 0 <there@test>\r\n")
+			case line == "STAT <gone@test>":
+				fmt.Fprint(conn, "430 No such article\r\n")
+			case line == "QUIT":
+				fmt.Fprint(conn, "205 Bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "500 What?\r\n")
+			}
+		}
+	}()
+
+	conn, err := dialNNTP(listener.Addr().String(), false, "user", "pass", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ok, err := conn.stat("<there@test>", time.Second); !ok || err != nil {
+		t.Errorf("STAT <there@test> = %v, %v", ok, err)
+	}
+	// Message ids are sent in angle brackets.
+	if ok, err := conn.stat("gone@test", time.Second); ok || err != nil {
+		t.Errorf("STAT <gone@test> = %v, %v", ok, err)
+	}
+	if _, err := conn.stat("<other>", time.Second); err == nil {
+		t.Errorf("STAT of an unexpected response didn't fail")
+	}
+}
@@ -0,0 +1,143 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/animezb/goes"
+	"strings"
+	"time"
+)
+
+const osSINK_NAME = "opensearch"
+
+// opensearchClient sends documents to OpenSearch, or ElasticSearch 7 and
+// later, over the bulk API. Indices are typeless: uploads, files and segments
+// each get their own index, named after the index of the document and the
+// type, and the _parent of a file or segment is stored in its upload_id or
+// file_id field.
+type opensearchClient struct {
+	*httpClient
+	index     indexTemplate
+	alias     string
+	bootstrap string
+}
+
+func newOpensearchClient(http *httpClient, index indexTemplate, alias string, bootstrap string) *opensearchClient {
+	return &opensearchClient{
+		httpClient: http,
+		index:      index,
+		alias:      alias,
+		bootstrap:  bootstrap,
+	}
+}
+
+// indexName returns the index of the documents of a type in an index of the
+// sink.
+func indexName(index interface{}, docType string) string {
+	return fmt.Sprint(index) + "-" + docType
+}
+
+// Setup creates or verifies the indices, or their index templates for time
+// based indices, if bootstrap is set.
+func (c *opensearchClient) Setup() error {
+	create := c.bootstrap == bootstrapCreate
+	switch {
+	case c.bootstrap == "":
+	case c.index.timeBased():
+		name := func(docType string) string { return indexName(c.alias, docType) }
+		pattern := func(docType string) string { return indexName(c.index.pattern(), docType) }
+		if err := bootstrapModernTemplate(c.httpClient, name, pattern, name, create); err != nil {
+			return err
+		}
+	default:
+		name := func(docType string) string { return indexName(c.index.name(time.Time{}), docType) }
+		if err := bootstrapModern(c.httpClient, name, create); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type osAction struct {
+	Index string      `json:"_index"`
+	Id    interface{} `json:"_id,omitempty"`
+}
+
+// fieldsOf returns the fields of a document as a JSON object. The fields of
+// replayed documents are JSON already.
+func fieldsOf(doc goes.Document) (map[string]json.RawMessage, error) {
+	raw, ok := doc.Fields.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(doc.Fields); err != nil {
+			return nil, err
+		}
+	}
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(raw, &fields)
+	return fields, err
+}
+
+// convert returns the action and source lines of a document.
+func (c *opensearchClient) convert(doc goes.Document) (action, source []byte, err error) {
+	fields, err := fieldsOf(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	index := doc.Index
+	if index == nil || index == "" {
+		return nil, nil, fmt.Errorf("No index to write it to.")
+	}
+	parentField := "upload_id"
+	if doc.Type == "segment" || doc.Type == "chunk" {
+		parentField = "file_id"
+	}
+	delete(fields, "_id")
+	if doc.Parent != nil {
+		parent, _ := json.Marshal(doc.Parent)
+		fields[parentField] = parent
+	}
+	if source, err = json.Marshal(fields); err != nil {
+		return nil, nil, err
+	}
+	action, err = json.Marshal(map[string]osAction{doc.BulkCommand: {Index: indexName(index, doc.Type), Id: doc.Id}})
+	return action, source, err
+}
+
+func (c *opensearchClient) BulkSend(docs []goes.Document) (bulkResponse, error) {
+	return sendBulk(c.httpClient, docs, c.convert)
+}
+
+// Get ignores parents, documents aren't routed by their parent.
+func (c *opensearchClient) Get(index, docType string, ids []string, parents map[string]string) (map[string]json.RawMessage, error) {
+	return c.mget("/"+indexName(index, docType)+"/_mget", ids, nil)
+}
+
+func (c *opensearchClient) Unchecked(before time.Time, size int) ([]searchHit, error) {
+	hits, err := c.search("/"+indexName(searchIndex(c.index, c.alias), "upload")+"/_search", map[string]interface{}{
+		"size": size,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"dmca": false}},
+					map[string]interface{}{"range": map[string]interface{}{"date": map[string]interface{}{"lt": before}}},
+				},
+			},
+		},
+		"sort": []interface{}{
+			map[string]interface{}{"dmca_checked": map[string]interface{}{"order": "asc", "missing": "_first", "unmapped_type": "date"}},
+		},
+	})
+	for i := range hits {
+		hits[i].Index = strings.TrimSuffix(hits[i].Index, "-upload")
+	}
+	return hits, err
+}
+
+func (c *opensearchClient) Find(ids []string) ([]searchHit, error) {
+	hits, err := c.search("/"+indexName(searchIndex(c.index, c.alias), "upload")+"/_search", idsQuery(ids))
+	for i := range hits {
+		hits[i].Index = strings.TrimSuffix(hits[i].Index, "-upload")
+	}
+	return hits, err
+}
@@ -0,0 +1,87 @@
+package elasticsink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/animezb/goes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpensearchBulkSend(t *testing.T) {
+	var lines []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Errorf("Invalid bulk line %s", scanner.Text())
+			}
+			lines = append(lines, line)
+		}
+		fmt.Fprint(w, `{"took": 3, "errors": true, "items": [
+			{"index": {"_id": "u", "status": 200}},
+			{"index": {"_id": "f", "status": 201}},
+			{"create": {"_id": "<s@test>", "status": 409, "error": {"type": "version_conflict_engine_exception", "reason": "document already exists"}}}
+		]}`)
+	}))
+	defer server.Close()
+	client, _ := newHttpClient(httpConfig{nodes: []string{server.URL}})
+	index, _ := parseIndexTemplate(ES_INDEX)
+	c := newOpensearchClient(client, index, "", "")
+
+	upload, _ := json.Marshal(Upload{Id: "u", Subject: "s"})
+	docs := []goes.Document{
+		{Index: ES_INDEX, Type: "upload", Id: "u", BulkCommand: "index", Fields: json.RawMessage(upload)},
+		{Index: ES_INDEX, Type: "file", Id: "f", Parent: "u", BulkCommand: "index", Fields: File{Id: "f", Filename: "a.rar"}},
+		{Index: ES_INDEX, Type: "segment", Id: "<s@test>", Parent: "f", BulkCommand: "create", Fields: Segment{MessageId: "<s@test>"}},
+	}
+	r, err := c.BulkSend(docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 6 {
+		t.Fatalf("Sent %d lines, want 6", len(lines))
+	}
+	action := lines[0]["index"].(map[string]interface{})
+	if action["_index"] != "nzb-upload" || action["_id"] != "u" {
+		t.Errorf("Upload action %v", action)
+	}
+	if lines[1]["subject"] != "s" || lines[1]["_id"] != nil {
+		t.Errorf("Upload source %v", lines[1])
+	}
+	if action := lines[2]["index"].(map[string]interface{}); action["_index"] != "nzb-file" {
+		t.Errorf("File action %v", action)
+	}
+	if lines[3]["upload_id"] != "u" || lines[3]["filename"] != "a.rar" {
+		t.Errorf("File source %v, want upload_id u", lines[3])
+	}
+	if action := lines[4]["create"].(map[string]interface{}); action["_index"] != "nzb-segment" {
+		t.Errorf("Segment action %v", action)
+	}
+	if lines[5]["file_id"] != "f" || lines[5]["message_id"] != "<s@test>" {
+		t.Errorf("Segment source %v", lines[5])
+	}
+
+	items, err := parseBulkItems(r.Items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != len(docs) || items[0].failed() || !items[2].exists(docs[2].BulkCommand) {
+		t.Errorf("Items %+v", items)
+	}
+
+	// A document without an index isn't written to a made up one.
+	lines = nil
+	if _, err := c.BulkSend([]goes.Document{{Type: "upload", Id: "u", BulkCommand: "index", Fields: json.RawMessage(upload)}}); err == nil {
+		t.Error("Sent a document without an index")
+	}
+	if len(lines) != 0 {
+		t.Errorf("Sent %d lines of a document without an index", len(lines))
+	}
+}
@@ -0,0 +1,54 @@
+package elasticsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/animezb/goes"
+)
+
+// ParseFailedDocument parses a line of the fail log or of the dead letter
+// queue holding a document, either a DeadLetter or a goes.Document written by
+// an older FailAll.
+func ParseFailedDocument(line []byte) (goes.Document, bool) {
+	var d DeadLetter
+	var raw struct {
+		BulkCommand string
+		Fields      json.RawMessage
+	}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&d); err != nil {
+		return goes.Document{}, false
+	}
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return goes.Document{}, false
+	}
+	if d.BulkCommand == "" {
+		d.BulkCommand = raw.BulkCommand
+	}
+	if d.BulkCommand == "" || d.Type == "" || d.Fields == nil {
+		return goes.Document{}, false
+	}
+	// Send the fields as they were written.
+	d.Fields = raw.Fields
+	return d.Document(), true
+}
+
+// Serving reports whether the sink accepts articles.
+func (es *ElasticSink) Serving() bool {
+	es.articlesLock.RLock()
+	defer es.articlesLock.RUnlock()
+	return es.articles != nil
+}
+
+// Err returns why Serve returned without serving, if it did.
+func (es *ElasticSink) Err() error {
+	return es.setupErr
+}
+
+// ReplayDocuments sends documents from the fail log or the dead letter queue
+// to ElasticSearch, in order, and returns how many of them failed again. The
+// sink must be serving.
+func (es *ElasticSink) ReplayDocuments(docs []goes.Document) int {
+	return len(es.bulkSend(docs))
+}
@@ -0,0 +1,38 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"github.com/animezb/goes"
+	"testing"
+)
+
+func TestParseFailedDocument(t *testing.T) {
+	doc := goes.Document{
+		Index:       ES_INDEX,
+		Type:        "segment",
+		Id:          "<part1of3.abc@example.com>",
+		Parent:      "a8f3e1c9b2d74e6f",
+		BulkCommand: "create",
+		Fields:      Segment{ServerArticleId: 9007199254740993, MessageId: "<part1of3.abc@example.com>"},
+	}
+	legacy, _ := json.Marshal(doc)
+	letter, _ := json.Marshal(newDeadLetter(doc, 400, "MapperParsingException"))
+	for _, line := range [][]byte{legacy, letter} {
+		d, ok := ParseFailedDocument(line)
+		if !ok {
+			t.Errorf("Failed to parse %s", line)
+			continue
+		}
+		if d.Type != doc.Type || d.Id != doc.Id || d.Parent != doc.Parent || d.BulkCommand != doc.BulkCommand {
+			t.Errorf("Parsed %s into %+v", line, d)
+		}
+		fields, _ := json.Marshal(d.Fields)
+		want, _ := json.Marshal(doc.Fields)
+		if string(fields) != string(want) {
+			t.Errorf("Parsed fields %s, want %s", fields, want)
+		}
+	}
+	if _, ok := ParseFailedDocument([]byte(`{"subject": "x yEnc (1/2)", "message_id": "<x@y>"}`)); ok {
+		t.Errorf("Parsed an article as a document")
+	}
+}
@@ -0,0 +1,513 @@
+package elasticsink
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	bootstrapVerify = "verify"
+	bootstrapCreate = "create"
+)
+
+// Kinds of fields, mapped to the field types of each backend.
+const (
+	kindText    = "text"
+	kindNgram   = "ngram"
+	kindKeyword = "keyword"
+	kindDate    = "date"
+	kindInteger = "integer"
+	kindLong    = "long"
+	kindDouble  = "double"
+	kindBoolean = "boolean"
+	kindObject  = "object"
+	// kindHidden fields are kept in the source but neither indexed nor
+	// searchable.
+	kindHidden = "hidden"
+)
+
+type schemaField struct {
+	Name  string
+	Kind  string
+	Store bool
+	// InAll includes the field in _all on ElasticSearch 1.x.
+	InAll  bool
+	Fields []schemaField
+}
+
+type schemaType struct {
+	Name    string
+	Parent  string
+	All     string
+	IdPath  string
+	Dynamic bool
+	Fields  []schemaField
+}
+
+// schema describes the documents of the sink, the mappings of both backends
+// are derived from it.
+var schema = []schemaType{
+	{
+		Name:    "upload",
+		All:     "ngram2_36_analyzer",
+		Dynamic: true,
+		Fields: []schemaField{
+			{Name: "poster", Kind: kindText, Store: true, InAll: true},
+			{Name: "subject", Kind: kindNgram, Store: true, InAll: true},
+			{Name: "date", Kind: kindDate, Store: true},
+			{Name: "group", Kind: kindKeyword, Store: true},
+			{Name: "dmca", Kind: kindBoolean},
+			{Name: "dmca_date", Kind: kindDate},
+			{Name: "dmca_missing", Kind: kindInteger},
+			{Name: "dmca_checked", Kind: kindDate},
+			{Name: "obfuscated", Kind: kindBoolean},
+			{Name: "length", Kind: kindInteger, Store: true},
+			{Name: "parts", Kind: kindInteger, Store: true},
+			{Name: "complete", Kind: kindInteger, Store: true},
+			{Name: "completion", Kind: kindDouble, Store: true},
+			{Name: "size", Kind: kindLong, Store: true},
+			{Name: "fileprefix", Kind: kindText, Store: true},
+			{Name: "types", Kind: kindHidden},
+			{Name: "progress", Kind: kindHidden},
+			{Name: "release", Kind: kindObject, Fields: []schemaField{
+				{Name: "name", Kind: kindText, Store: true},
+				{Name: "group", Kind: kindKeyword, Store: true},
+				{Name: "title", Kind: kindText, Store: true, InAll: true},
+				{Name: "episode", Kind: kindKeyword, Store: true},
+				{Name: "resolution", Kind: kindKeyword, Store: true},
+				{Name: "crc", Kind: kindKeyword, Store: true},
+				{Name: "codec", Kind: kindKeyword, Store: true},
+			}},
+		},
+	},
+	{
+		Name:   "file",
+		Parent: "upload",
+		All:    "standard",
+		Fields: []schemaField{
+			{Name: "upload_id", Kind: kindKeyword},
+			{Name: "poster", Kind: kindText, InAll: true},
+			{Name: "subject", Kind: kindText, InAll: true},
+			{Name: "filename", Kind: kindText, InAll: true},
+			{Name: "date", Kind: kindDate},
+			{Name: "group", Kind: kindKeyword},
+			{Name: "length", Kind: kindInteger},
+			{Name: "index", Kind: kindInteger},
+			{Name: "complete", Kind: kindInteger},
+			{Name: "completion", Kind: kindDouble},
+			{Name: "size", Kind: kindLong},
+			{Name: "dmca", Kind: kindBoolean},
+			{Name: "dmca_date", Kind: kindDate},
+			{Name: "dmca_missing", Kind: kindInteger},
+			{Name: "segments", Kind: kindHidden},
+			{Name: "chunks", Kind: kindInteger},
+		},
+	},
+	{
+		Name:   "chunk",
+		Parent: "file",
+		All:    "standard",
+		Fields: []schemaField{
+			{Name: "file_id", Kind: kindKeyword},
+			{Name: "chunk", Kind: kindInteger},
+			{Name: "segments", Kind: kindHidden},
+		},
+	},
+	{
+		Name:   "segment",
+		Parent: "file",
+		All:    "standard",
+		IdPath: "message_id",
+		Fields: []schemaField{
+			{Name: "file_id", Kind: kindKeyword},
+			{Name: "poster", Kind: kindText, InAll: true},
+			{Name: "subject", Kind: kindText, InAll: true},
+			{Name: "filename", Kind: kindText, InAll: true},
+			{Name: "date", Kind: kindDate},
+			{Name: "group", Kind: kindKeyword},
+			{Name: "bytes", Kind: kindLong},
+			{Name: "server_article_id", Kind: kindLong},
+			{Name: "message_id", Kind: kindKeyword},
+			{Name: "part", Kind: kindInteger},
+			{Name: "length", Kind: kindInteger},
+			{Name: "file", Kind: kindInteger},
+			{Name: "added", Kind: kindDate},
+		},
+	},
+}
+
+// analysisSettings are the analyzers of the indices, with the ngram token
+// filter named filterType.
+func analysisSettings(filterType string) map[string]interface{} {
+	return map[string]interface{}{
+		"analyzer": map[string]interface{}{
+			"ngram2_36_analyzer": map[string]interface{}{
+				"type":      "custom",
+				"tokenizer": "standard",
+				"filter":    []string{"lowercase", "nGram2_36"},
+			},
+		},
+		"filter": map[string]interface{}{
+			"nGram2_36": map[string]interface{}{
+				"type":     filterType,
+				"min_gram": 2,
+				"max_gram": 36,
+			},
+		},
+	}
+}
+
+// legacySettings are the settings of the indices on ElasticSearch 1.x.
+func legacySettings() map[string]interface{} {
+	return map[string]interface{}{"analysis": analysisSettings("nGram")}
+}
+
+// modernSettings are the settings of the indices on OpenSearch, which
+// rejects the nGram name and ngrams of more than max_ngram_diff lengths.
+func modernSettings() map[string]interface{} {
+	return map[string]interface{}{
+		"index.max_ngram_diff": 34,
+		"analysis":             analysisSettings("ngram"),
+	}
+}
+
+// legacy returns the mapping of the field on ElasticSearch 1.x.
+func (f schemaField) legacy() map[string]interface{} {
+	m := map[string]interface{}{}
+	switch f.Kind {
+	case kindText:
+		m["type"] = "string"
+	case kindNgram:
+		m["type"] = "string"
+		m["index_analyzer"] = "ngram2_36_analyzer"
+	case kindKeyword:
+		m["type"] = "string"
+		m["index"] = "not_analyzed"
+	case kindDate:
+		m["type"] = "date"
+		m["format"] = "date_time_no_millis||date_time"
+	case kindHidden:
+		m["type"] = "object"
+		m["enabled"] = false
+	case kindObject:
+		m["type"] = "object"
+		props := map[string]interface{}{}
+		for _, sub := range f.Fields {
+			props[sub.Name] = sub.legacy()
+		}
+		m["properties"] = props
+		return m
+	default:
+		m["type"] = f.Kind
+	}
+	if f.Kind != kindHidden {
+		if f.Store {
+			m["store"] = true
+		}
+		if !f.InAll {
+			m["include_in_all"] = false
+		}
+	}
+	return m
+}
+
+// modern returns the mapping of the field on OpenSearch.
+func (f schemaField) modern() map[string]interface{} {
+	m := map[string]interface{}{}
+	switch f.Kind {
+	case kindNgram:
+		m["type"] = "text"
+		m["analyzer"] = "ngram2_36_analyzer"
+		m["search_analyzer"] = "standard"
+	case kindHidden:
+		m["type"] = "object"
+		m["enabled"] = false
+	case kindObject:
+		m["type"] = "object"
+		props := map[string]interface{}{}
+		for _, sub := range f.Fields {
+			props[sub.Name] = sub.modern()
+		}
+		m["properties"] = props
+	default:
+		m["type"] = f.Kind
+	}
+	return m
+}
+
+// legacyMapping returns the mapping of the type on ElasticSearch 1.x.
+func (t schemaType) legacyMapping() map[string]interface{} {
+	props := map[string]interface{}{}
+	for _, f := range t.Fields {
+		if f.Name == "upload_id" || f.Name == "file_id" {
+			// _parent.
+			continue
+		}
+		props[f.Name] = f.legacy()
+	}
+	m := map[string]interface{}{
+		"_all":       map[string]interface{}{"index_analyzer": t.All},
+		"properties": props,
+	}
+	if !t.Dynamic {
+		m["dynamic"] = false
+	}
+	if t.Parent != "" {
+		m["_parent"] = map[string]interface{}{"type": t.Parent}
+	}
+	if t.IdPath != "" {
+		m["_id"] = map[string]interface{}{"path": t.IdPath}
+	}
+	return m
+}
+
+// modernMapping returns the mapping of the index of the type on OpenSearch.
+func (t schemaType) modernMapping() map[string]interface{} {
+	props := map[string]interface{}{}
+	for _, f := range t.Fields {
+		props[f.Name] = f.modern()
+	}
+	m := map[string]interface{}{"properties": props}
+	if !t.Dynamic {
+		m["dynamic"] = false
+	}
+	return m
+}
+
+// mappingConflicts compares the properties of a live mapping to the ones
+// expected, it returns the fields whose type differ, and the missing ones.
+func mappingConflicts(want, live map[string]interface{}, prefix string) (conflicts, missing []string) {
+	wantProps, _ := want["properties"].(map[string]interface{})
+	liveProps, _ := live["properties"].(map[string]interface{})
+	names := make([]string, 0, len(wantProps))
+	for name := range wantProps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w, _ := wantProps[name].(map[string]interface{})
+		l, ok := liveProps[name].(map[string]interface{})
+		if !ok {
+			missing = append(missing, prefix+name)
+			continue
+		}
+		wantType, liveType := w["type"], l["type"]
+		if liveType == nil {
+			// Objects are reported without a type.
+			liveType = "object"
+		}
+		if wantType != liveType {
+			conflicts = append(conflicts, fmt.Sprintf("%s%s is %v, want %v", prefix, name, liveType, wantType))
+			continue
+		}
+		if wantType == "object" && w["properties"] != nil {
+			c, m := mappingConflicts(w, l, prefix+name+".")
+			conflicts = append(conflicts, c...)
+			missing = append(missing, m...)
+		}
+	}
+	return conflicts, missing
+}
+
+// bootstrapMapping checks the live mapping of a type against the schema. The
+// missing fields are added when create is set, conflicting ones are an error.
+func bootstrapMapping(path string, want map[string]interface{}, live map[string]interface{}, put func() error, create bool) error {
+	conflicts, missing := mappingConflicts(want, live, "")
+	if len(conflicts) > 0 {
+		return fmt.Errorf("Mapping of %s is incompatible: %v.", path, conflicts)
+	}
+	if len(missing) > 0 {
+		if !create {
+			return fmt.Errorf("Mapping of %s is missing %v.", path, missing)
+		}
+		return put()
+	}
+	return nil
+}
+
+// bootstrapLegacy creates or verifies the index and the mappings of
+// ElasticSearch 1.x.
+func bootstrapLegacy(c *httpClient, index string, create bool) error {
+	ok, err := c.exists("/" + index)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if !create {
+			return fmt.Errorf("Index %s does not exist.", index)
+		}
+		if err := c.json("PUT", "/"+index, map[string]interface{}{"settings": legacySettings()}, nil); err != nil {
+			return err
+		}
+	}
+	for _, t := range schema {
+		want := t.legacyMapping()
+		var live map[string]struct {
+			Mappings map[string]map[string]interface{} `json:"mappings"`
+		}
+		path := "/" + index + "/_mapping/" + t.Name
+		if err := c.json("GET", path, nil, &live); err != nil {
+			return err
+		}
+		put := func() error {
+			return c.json("PUT", path, map[string]interface{}{t.Name: want}, nil)
+		}
+		if err := bootstrapMapping(path, want, live[index].Mappings[t.Name], put, create); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootstrapModern creates or verifies the indices of OpenSearch, one per
+// type.
+func bootstrapModern(c *httpClient, indexName func(string) string, create bool) error {
+	for _, t := range schema {
+		index := indexName(t.Name)
+		want := t.modernMapping()
+		ok, err := c.exists("/" + index)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if !create {
+				return fmt.Errorf("Index %s does not exist.", index)
+			}
+			body := map[string]interface{}{"settings": modernSettings(), "mappings": want}
+			if err := c.json("PUT", "/"+index, body, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		var live map[string]struct {
+			Mappings map[string]interface{} `json:"mappings"`
+		}
+		path := "/" + index + "/_mapping"
+		if err := c.json("GET", path, nil, &live); err != nil {
+			return err
+		}
+		put := func() error {
+			return c.json("PUT", path, want, nil)
+		}
+		if err := bootstrapMapping(path, want, live[index].Mappings, put, create); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootstrapLegacyTemplate creates or verifies the index template of
+// ElasticSearch 1.x for time based indices, new indices get the settings,
+// the mappings and the search alias from it. Indices created before the
+// template changed keep their mappings.
+func bootstrapLegacyTemplate(c *httpClient, name, pattern, alias string, create bool) error {
+	path := "/_template/" + name
+	mappings := make(map[string]interface{})
+	for _, t := range schema {
+		mappings[t.Name] = t.legacyMapping()
+	}
+	put := func() error {
+		body := map[string]interface{}{
+			"template": pattern,
+			"settings": legacySettings(),
+			"mappings": mappings,
+			"aliases":  map[string]interface{}{alias: struct{}{}},
+		}
+		return c.json("PUT", path, body, nil)
+	}
+	ok, err := c.exists(path)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if !create {
+			return fmt.Errorf("Index template %s does not exist.", name)
+		}
+		return put()
+	}
+	var live map[string]struct {
+		Template string                            `json:"template"`
+		Mappings map[string]map[string]interface{} `json:"mappings"`
+		Aliases  map[string]interface{}            `json:"aliases"`
+	}
+	if err := c.json("GET", path, nil, &live); err != nil {
+		return err
+	}
+	if _, ok := live[name].Aliases[alias]; live[name].Template != pattern || !ok {
+		if !create {
+			return fmt.Errorf("Index template %s does not match %s with alias %s.", name, pattern, alias)
+		}
+		return put()
+	}
+	for _, t := range schema {
+		if err := bootstrapMapping(path+" "+t.Name, mappings[t.Name].(map[string]interface{}), live[name].Mappings[t.Name], put, create); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootstrapModernTemplate creates or verifies the index templates of
+// OpenSearch for time based indices, one per type.
+func bootstrapModernTemplate(c *httpClient, name func(string) string, pattern func(string) string, alias func(string) string, create bool) error {
+	for _, t := range schema {
+		path := "/_index_template/" + name(t.Name)
+		want := t.modernMapping()
+		put := func() error {
+			body := map[string]interface{}{
+				"index_patterns": []string{pattern(t.Name)},
+				"template": map[string]interface{}{
+					"settings": modernSettings(),
+					"mappings": want,
+					"aliases":  map[string]interface{}{alias(t.Name): struct{}{}},
+				},
+			}
+			return c.json("PUT", path, body, nil)
+		}
+		ok, err := c.exists(path)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if !create {
+				return fmt.Errorf("Index template %s does not exist.", name(t.Name))
+			}
+			if err := put(); err != nil {
+				return err
+			}
+			continue
+		}
+		var live struct {
+			IndexTemplates []struct {
+				IndexTemplate struct {
+					IndexPatterns []string `json:"index_patterns"`
+					Template      struct {
+						Mappings map[string]interface{} `json:"mappings"`
+						Aliases  map[string]interface{} `json:"aliases"`
+					} `json:"template"`
+				} `json:"index_template"`
+			} `json:"index_templates"`
+		}
+		if err := c.json("GET", path, nil, &live); err != nil {
+			return err
+		}
+		if len(live.IndexTemplates) != 1 {
+			return fmt.Errorf("Index template %s not found.", name(t.Name))
+		}
+		tmpl := live.IndexTemplates[0].IndexTemplate
+		_, aliased := tmpl.Template.Aliases[alias(t.Name)]
+		if len(tmpl.IndexPatterns) != 1 || tmpl.IndexPatterns[0] != pattern(t.Name) || !aliased {
+			if !create {
+				return fmt.Errorf("Index template %s does not match %s with alias %s.", name(t.Name), pattern(t.Name), alias(t.Name))
+			}
+			if err := put(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := bootstrapMapping(path, want, tmpl.Template.Mappings, put, create); err != nil {
+			return err
+		}
+	}
+	return nil
+}
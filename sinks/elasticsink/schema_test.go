@@ -0,0 +1,124 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// goKinds returns the kinds of field a Go type can be mapped to.
+func goKinds(typ reflect.Type) []string {
+	if typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	if typ == reflect.TypeOf(time.Time{}) {
+		return []string{kindDate}
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return []string{kindText, kindNgram, kindKeyword}
+	case reflect.Bool:
+		return []string{kindBoolean}
+	case reflect.Int, reflect.Int32:
+		return []string{kindInteger}
+	case reflect.Int64:
+		return []string{kindLong}
+	case reflect.Float32, reflect.Float64:
+		return []string{kindDouble}
+	case reflect.Struct:
+		return []string{kindObject}
+	}
+	return nil
+}
+
+// checkFields fails when a field of a document is missing from the schema,
+// or its Go type doesn't match the kind it is mapped to.
+func checkFields(t *testing.T, path string, typ reflect.Type, schemaFields []schemaField) {
+	fields := make(map[string]schemaField)
+	for _, f := range schemaFields {
+		fields[f.Name] = f
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || name == "_id" || name == "segments" {
+			continue
+		}
+		f, ok := fields[name]
+		if !ok {
+			t.Errorf("Field %s of %s is missing from the schema", name, path)
+			continue
+		}
+		if f.Kind == kindHidden {
+			continue
+		}
+		kinds := goKinds(typ.Field(i).Type)
+		matched := false
+		for _, k := range kinds {
+			if k == f.Kind {
+				matched = true
+			}
+		}
+		if !matched {
+			t.Errorf("Field %s of %s is a %s, mapped as %s", name, path, typ.Field(i).Type, f.Kind)
+		}
+		if f.Kind == kindObject {
+			ft := typ.Field(i).Type
+			if ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice {
+				ft = ft.Elem()
+			}
+			checkFields(t, path+"."+name, ft, f.Fields)
+		}
+	}
+}
+
+// TestSchemaCoversDocuments fails when a field is added to a document
+// without adding it to the schema, or its type changes without changing its
+// kind in the schema.
+func TestSchemaCoversDocuments(t *testing.T) {
+	docs := map[string]reflect.Type{
+		"upload":  reflect.TypeOf(Upload{}),
+		"file":    reflect.TypeOf(File{}),
+		"segment": reflect.TypeOf(Segment{}),
+		"chunk":   reflect.TypeOf(Chunk{}),
+	}
+	for _, st := range schema {
+		checkFields(t, st.Name, docs[st.Name], st.Fields)
+	}
+}
+
+func TestMappingConflicts(t *testing.T) {
+	var live map[string]interface{}
+	data, _ := json.Marshal(schema[0].modernMapping())
+	json.Unmarshal(data, &live)
+	if c, m := mappingConflicts(schema[0].modernMapping(), live, ""); len(c) > 0 || len(m) > 0 {
+		t.Errorf("Mapping conflicts with itself: %v, missing %v", c, m)
+	}
+
+	props := live["properties"].(map[string]interface{})
+	props["size"] = map[string]interface{}{"type": "text"}
+	delete(props, "parts")
+	props["release"].(map[string]interface{})["properties"].(map[string]interface{})["crc"] = map[string]interface{}{"type": "long"}
+	c, m := mappingConflicts(schema[0].modernMapping(), live, "")
+	if len(c) != 2 || !strings.HasPrefix(c[0], "release.crc ") || !strings.HasPrefix(c[1], "size ") {
+		t.Errorf("Conflicts %v", c)
+	}
+	if len(m) != 1 || m[0] != "parts" {
+		t.Errorf("Missing %v, want [parts]", m)
+	}
+}
+
+func TestIndexSettings(t *testing.T) {
+	filter := func(settings map[string]interface{}) map[string]interface{} {
+		analysis := settings["analysis"].(map[string]interface{})
+		return analysis["filter"].(map[string]interface{})["nGram2_36"].(map[string]interface{})
+	}
+	modern := modernSettings()
+	if f := filter(modern); f["type"] != "ngram" || f["max_gram"].(int)-f["min_gram"].(int) > modern["index.max_ngram_diff"].(int) {
+		t.Errorf("OpenSearch ngram filter %v, max_ngram_diff %v", f, modern["index.max_ngram_diff"])
+	}
+	if f := filter(legacySettings()); f["type"] != "nGram" {
+		t.Errorf("ElasticSearch 1.x ngram filter %v", f)
+	}
+}
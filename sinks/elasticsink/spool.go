@@ -0,0 +1,204 @@
+package elasticsink
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/animezb/newsrover"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// spool is a queue of articles in a file, one JSON article per line. Articles
+// are appended at the end and read back in order; the file is truncated
+// whenever it has been read entirely.
+type spool struct {
+	lock   sync.Mutex
+	path   string
+	file   *os.File
+	read   *os.File
+	reader *bufio.Reader
+	offset int64
+	count  int64
+	ready  chan bool
+	// head is the oldest article once peeked, headLen its length in the
+	// file. It stays in the file until it is popped.
+	head    *newsrover.Article
+	headLen int64
+}
+
+func openSpool(path string) (*spool, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := &spool{path: path, file: f, read: r, ready: make(chan bool, 1)}
+	s.reader = bufio.NewReader(r)
+	// Count the articles left from a previous run.
+	lines := bufio.NewReader(io.NewSectionReader(f, 0, 1<<62))
+	for {
+		if _, err := lines.ReadSlice('\n'); err == nil || err == bufio.ErrBufferFull {
+			if err == nil {
+				s.count++
+			}
+			continue
+		}
+		break
+	}
+	if s.count > 0 {
+		s.notify()
+	}
+	return s, nil
+}
+
+func (s *spool) notify() {
+	select {
+	case s.ready <- true:
+	default:
+	}
+}
+
+// Len returns the number of articles in the spool.
+func (s *spool) Len() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.count
+}
+
+func (s *spool) push(articles ...newsrover.Article) error {
+	var buf []byte
+	for _, a := range articles {
+		line, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.file.Write(buf); err != nil {
+		return err
+	}
+	s.count += int64(len(articles))
+	s.notify()
+	return nil
+}
+
+// peek returns the oldest article of the spool without removing it. It
+// returns false when the spool is empty.
+func (s *spool) peek() (newsrover.Article, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.peekLocked()
+}
+
+func (s *spool) peekLocked() (newsrover.Article, bool, error) {
+	if s.head != nil {
+		return *s.head, true, nil
+	}
+	for s.count > 0 {
+		line, err := s.reader.ReadBytes('\n')
+		if err != nil {
+			return newsrover.Article{}, false, err
+		}
+		var a newsrover.Article
+		if err := json.Unmarshal(line, &a); err == nil {
+			s.head = &a
+			s.headLen = int64(len(line))
+			return a, true, nil
+		}
+		// Unreadable articles are skipped.
+		if err := s.consume(int64(len(line))); err != nil {
+			return newsrover.Article{}, false, err
+		}
+	}
+	return newsrover.Article{}, false, nil
+}
+
+// pop removes and returns the oldest article of the spool. It returns false
+// when the spool is empty.
+func (s *spool) pop() (newsrover.Article, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok, err := s.peekLocked()
+	if !ok {
+		return a, ok, err
+	}
+	s.head = nil
+	return a, true, s.consume(s.headLen)
+}
+
+// consume removes the line of n bytes at the start of the spool.
+func (s *spool) consume(n int64) error {
+	s.offset += n
+	s.count--
+	if s.count == 0 {
+		return s.reset()
+	}
+	return nil
+}
+
+// each calls fn with the articles of the spool, oldest first, without
+// removing them.
+func (s *spool) each(fn func(newsrover.Article)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lines := bufio.NewReader(io.NewSectionReader(s.file, s.offset, 1<<62))
+	for {
+		line, err := lines.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var a newsrover.Article
+		if err := json.Unmarshal(line, &a); err == nil {
+			fn(a)
+		}
+	}
+}
+
+// reset truncates the spool once it has been read entirely.
+func (s *spool) reset() error {
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.read.Seek(0, 0); err != nil {
+		return err
+	}
+	s.offset = 0
+	s.reader.Reset(s.read)
+	return nil
+}
+
+// Close closes the spool, removing the articles already popped from the file
+// so they aren't read again when it is opened next.
+func (s *spool) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.file.Close()
+	defer s.read.Close()
+	if s.offset == 0 {
+		return nil
+	}
+	tmp, err := os.Create(filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp"))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, io.NewSectionReader(s.file, s.offset, 1<<62)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
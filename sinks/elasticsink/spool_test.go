@@ -0,0 +1,54 @@
+package elasticsink
+
+import (
+	"fmt"
+	"github.com/animezb/newsrover"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill.log")
+	s, err := openSpool(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := s.push(newsrover.Article{MessageId: fmt.Sprintf("<%d@test>", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if a, ok, err := s.pop(); err != nil || !ok || a.MessageId != fmt.Sprintf("<%d@test>", i) {
+			t.Fatalf("pop returned %v, %v, %v", a.MessageId, ok, err)
+		}
+	}
+	// A peeked article stays in the spool.
+	if a, ok, err := s.peek(); err != nil || !ok || a.MessageId != "<2@test>" {
+		t.Fatalf("peek returned %v, %v, %v", a.MessageId, ok, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openSpool(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n := s.Len(); n != 3 {
+		t.Fatalf("Reopened spool holds %d articles, want 3", n)
+	}
+	for i := 2; i < 5; i++ {
+		if a, ok, err := s.pop(); err != nil || !ok || a.MessageId != fmt.Sprintf("<%d@test>", i) {
+			t.Fatalf("pop returned %v, %v, %v", a.MessageId, ok, err)
+		}
+	}
+	if _, ok, _ := s.pop(); ok {
+		t.Errorf("pop returned an article from an empty spool")
+	}
+	s.push(newsrover.Article{MessageId: "<5@test>"})
+	if a, ok, _ := s.pop(); !ok || a.MessageId != "<5@test>" {
+		t.Errorf("pop after truncation returned %v, %v", a.MessageId, ok)
+	}
+}
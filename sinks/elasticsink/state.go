@@ -0,0 +1,414 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// partRange is a run of consecutive part numbers.
+type partRange struct {
+	first, last int
+}
+
+// partSet is a set of part numbers, kept as sorted ranges and written as a
+// string like 1-40,42,44-50, so a complete file takes a few bytes.
+type partSet []partRange
+
+// add adds a part to the set, it returns false if the part was in it.
+func (s *partSet) add(part int) bool {
+	r := *s
+	i := sort.Search(len(r), func(i int) bool { return r[i].last >= part-1 })
+	if i < len(r) && r[i].first <= part && part <= r[i].last {
+		return false
+	}
+	switch {
+	case i < len(r) && r[i].last == part-1:
+		r[i].last = part
+		if i+1 < len(r) && r[i+1].first == part+1 {
+			r[i].last = r[i+1].last
+			r = append(r[:i+1], r[i+2:]...)
+		}
+	case i < len(r) && r[i].first == part+1:
+		r[i].first = part
+	default:
+		r = append(r, partRange{})
+		copy(r[i+1:], r[i:])
+		r[i] = partRange{part, part}
+	}
+	*s = r
+	return true
+}
+
+// has returns true if the part is in the set.
+func (s partSet) has(part int) bool {
+	i := sort.Search(len(s), func(i int) bool { return s[i].last >= part })
+	return i < len(s) && s[i].first <= part
+}
+
+// Len returns the number of parts in the set.
+func (s partSet) Len() int {
+	n := 0
+	for _, r := range s {
+		n += r.last - r.first + 1
+	}
+	return n
+}
+
+func (s partSet) String() string {
+	ranges := make([]string, len(s))
+	for i, r := range s {
+		if r.first == r.last {
+			ranges[i] = strconv.Itoa(r.first)
+		} else {
+			ranges[i] = strconv.Itoa(r.first) + "-" + strconv.Itoa(r.last)
+		}
+	}
+	return strings.Join(ranges, ",")
+}
+
+func (s partSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *partSet) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	*s = nil
+	if str == "" {
+		return nil
+	}
+	for _, r := range strings.Split(str, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return fmt.Errorf("Invalid part range %s.", r)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return fmt.Errorf("Invalid part range %s.", r)
+			}
+		}
+		for part := first; part <= last; part++ {
+			s.add(part)
+		}
+	}
+	return nil
+}
+
+// fileState is what the sink knows of a file of an upload. The states of the
+// files are kept in the progress field of the upload document, so the
+// upload can be merged with new segments after the sink restarts.
+type fileState struct {
+	Id       string    `json:"id"`
+	Filename string    `json:"filename"`
+	Subject  string    `json:"subject"`
+	Index    int       `json:"index"`
+	Length   int       `json:"length"`
+	Size     int64     `json:"size"`
+	Date     time.Time `json:"date"`
+	Group    []string  `json:"group"`
+	Parts    partSet   `json:"parts"`
+	// Sample is a random sample of the message ids of the segments, the
+	// DMCA check asks the news server for them.
+	Sample []string `json:"sample,omitempty"`
+
+	Dmca        bool       `json:"dmca"`
+	DmcaDate    *time.Time `json:"dmca_date,omitempty"`
+	DmcaMissing int        `json:"dmca_missing"`
+
+	// segments are the compact segments of the file, kept out of the upload
+	// document. They are loaded from the file document in compaction mode,
+	// once segmentsLoaded.
+	segments       []CompactSegment
+	segmentsLoaded bool
+}
+
+// fileSampleSize is the number of message ids sampled per file.
+const fileSampleSize = 8
+
+// sampleSegment adds the message id of a new segment to the sample, every
+// segment of the file has the same chance to be in it.
+func (fs *fileState) sampleSegment(messageId string) {
+	if len(fs.Sample) < fileSampleSize {
+		fs.Sample = append(fs.Sample, messageId)
+	} else if i := rand.Intn(fs.Parts.Len()); i < fileSampleSize {
+		fs.Sample[i] = messageId
+	}
+}
+
+// sampledSegment is a message id sampled from a file.
+type sampledSegment struct {
+	file      *fileState
+	messageId string
+}
+
+// uploadState is the merged upload document and the index it is written to.
+// It is changed by the worker of the upload and by the DMCA check, under its
+// lock.
+type uploadState struct {
+	lock   sync.Mutex
+	index  string
+	upload Upload
+	files  map[string]*fileState
+}
+
+// newUploadState returns the state of an upload, from its document.
+func newUploadState(index string, u Upload) *uploadState {
+	st := &uploadState{index: index, upload: u, files: make(map[string]*fileState)}
+	if st.upload.Types == nil {
+		st.upload.Types = make(map[string]int)
+	}
+	for _, f := range st.upload.Progress {
+		st.files[f.Id] = f
+	}
+	if st.upload.Parts == 0 && len(st.upload.Progress) > 0 {
+		// Documents written before parts have the sum of the lengths
+		// of the files in length.
+		for _, f := range st.upload.Progress {
+			st.upload.Parts += f.Length
+		}
+		st.upload.Length = len(st.upload.Progress)
+	}
+	return st
+}
+
+// mergeUpload adds the groups and the number of files of an upload seen in
+// the articles to the state.
+func (st *uploadState) mergeUpload(u Upload) {
+	st.upload.Group = mergeGroups(st.upload.Group, u.Group)
+	if u.Length > 0 {
+		st.upload.Length = u.Length
+	}
+}
+
+// mergeFile adds the segments of a file seen in the articles to the state of
+// the file and of the upload, like RoverUpdateScript did on the cluster:
+// segments seen before are ignored, the date is the one of the last
+// segment, and the parts of the upload are the sum of the lengths of its
+// files. It returns the state of the file and the segments that were new.
+func (st *uploadState) mergeFile(f File) (*fileState, []*Segment) {
+	u := &st.upload
+	fs, ok := st.files[f.Id]
+	if !ok {
+		fs = &fileState{
+			Id:       f.Id,
+			Filename: f.Filename,
+			Subject:  f.Subject,
+			Index:    f.Index,
+			Length:   f.Length,
+			// A new file has no segments to load.
+			segmentsLoaded: true,
+		}
+		st.files[f.Id] = fs
+		u.Progress = append(u.Progress, fs)
+		if u.FilePrefix == "" && len(u.Progress) == 1 {
+			u.FilePrefix = f.Filename
+		} else {
+			u.FilePrefix = commonPrefix(u.FilePrefix, f.Filename)
+		}
+		if ext := fileType(f.Filename); ext != "" {
+			u.Types[ext]++
+		}
+		u.Parts += f.Length
+	}
+	fs.Group = mergeGroups(fs.Group, f.Group)
+	var added []*Segment
+	for _, s := range f.Segments {
+		if !fs.Parts.add(s.Part) {
+			continue
+		}
+		added = append(added, s)
+		if s.Date.After(fs.Date) {
+			fs.Date = s.Date
+		}
+		if s.Date.After(u.Date) {
+			u.Date = s.Date
+		}
+		fs.sampleSegment(s.MessageId)
+		fs.Size += s.Bytes
+		u.Size += s.Bytes
+		u.Complete++
+	}
+	u.Completion = completion(u.Complete, u.Parts)
+	return fs, added
+}
+
+// sample returns up to n message ids of the samples of the files, at random.
+func (st *uploadState) sample(n int) []sampledSegment {
+	var segments []sampledSegment
+	for _, fs := range st.upload.Progress {
+		for _, id := range fs.Sample {
+			segments = append(segments, sampledSegment{fs, id})
+		}
+	}
+	rand.Shuffle(len(segments), func(i, j int) {
+		segments[i], segments[j] = segments[j], segments[i]
+	})
+	if len(segments) > n {
+		segments = segments[:n]
+	}
+	return segments
+}
+
+// file returns the document of a file of the upload.
+func (st *uploadState) file(fs *fileState) File {
+	complete := fs.Parts.Len()
+	return File{
+		Id:          fs.Id,
+		Dmca:        fs.Dmca,
+		DmcaDate:    fs.DmcaDate,
+		DmcaMissing: fs.DmcaMissing,
+		Poster:      st.upload.Poster,
+		Subject:     fs.Subject,
+		Date:        fs.Date,
+		Group:       fs.Group,
+		Length:      fs.Length,
+		Complete:    complete,
+		Completion:  completion(complete, fs.Length),
+		Size:        fs.Size,
+		Filename:    fs.Filename,
+		Index:       fs.Index,
+		ParentId:    st.upload.Id,
+	}
+}
+
+// bufferedProgress tracks the progress of an upload with the segments a
+// worker buffered, to flush them as soon as they complete the upload. The
+// state of the upload may be stale or missing, so the upload may look
+// complete early, which only flushes it early.
+type bufferedProgress struct {
+	st       *uploadState
+	files    map[string]*partSet
+	complete int
+	length   int
+	known    int
+	total    int
+	done     bool
+}
+
+// newBufferedProgress returns the progress of an upload of total files,
+// from its state, which may be nil.
+func newBufferedProgress(st *uploadState, total int) *bufferedProgress {
+	p := &bufferedProgress{st: st, files: make(map[string]*partSet), total: total}
+	if st != nil {
+		st.lock.Lock()
+		u := st.upload
+		p.complete, p.length, p.known = u.Complete, u.Parts, len(st.files)
+		if u.Length > p.total {
+			p.total = u.Length
+		}
+		st.lock.Unlock()
+	}
+	p.done = p.isComplete()
+	return p
+}
+
+func (p *bufferedProgress) isComplete() bool {
+	return p.length > 0 && p.complete >= p.length && p.known >= p.total
+}
+
+// add adds a buffered segment of a file of length parts, it returns true if
+// the segment completes the upload.
+func (p *bufferedProgress) add(fileId string, part, length int) bool {
+	merged := false
+	inState := false
+	if p.st != nil {
+		p.st.lock.Lock()
+		if fs, ok := p.st.files[fileId]; ok {
+			inState = true
+			merged = fs.Parts.has(part)
+		}
+		p.st.lock.Unlock()
+	}
+	parts, ok := p.files[fileId]
+	if !ok {
+		parts = new(partSet)
+		p.files[fileId] = parts
+		if !inState {
+			p.length += length
+			p.known++
+		}
+	}
+	if parts.add(part) && !merged {
+		p.complete++
+	}
+	if p.done || !p.isComplete() {
+		return false
+	}
+	p.done = true
+	return true
+}
+
+func completion(complete, length int) float64 {
+	if length == 0 {
+		return 0
+	}
+	return float64(complete) / float64(length)
+}
+
+func mergeGroups(groups []string, more []string) []string {
+	for _, g := range more {
+		found := false
+		for _, h := range groups {
+			if g == h {
+				found = true
+				break
+			}
+		}
+		if !found {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+func commonPrefix(a, b string) string {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+// fileType returns the extension of a filename, split for the numbered
+// parts of a split file and rar for the .r00 volumes of a rar.
+func fileType(filename string) string {
+	filename = strings.ToLower(filename)
+	ext := ""
+	if i := strings.LastIndex(filename, "."); i >= 0 {
+		ext = filename[i+1:]
+	}
+	if isInteger(ext) {
+		return "split"
+	}
+	if strings.HasPrefix(ext, "r") && isInteger(ext[1:]) {
+		return "rar"
+	}
+	return ext
+}
+
+func isInteger(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
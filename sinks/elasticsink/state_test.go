@@ -0,0 +1,142 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPartSet(t *testing.T) {
+	var s partSet
+	for _, part := range []int{5, 1, 3, 2, 7, 4, 10, 9} {
+		if !s.add(part) {
+			t.Errorf("Part %d was added before", part)
+		}
+	}
+	if s.add(3) {
+		t.Errorf("Part 3 added twice")
+	}
+	if s.String() != "1-5,7,9-10" || s.Len() != 8 {
+		t.Errorf("Set %s of %d parts, want 1-5,7,9-10", s, s.Len())
+	}
+	if !s.has(1) || !s.has(5) || !s.has(9) || s.has(6) || s.has(0) || s.has(11) {
+		t.Errorf("Set %s has the wrong parts", s)
+	}
+	data, _ := json.Marshal(s)
+	var back partSet
+	if err := json.Unmarshal(data, &back); err != nil || back.String() != s.String() {
+		t.Errorf("Decoded %s from %s (%v)", back, data, err)
+	}
+	if err := json.Unmarshal([]byte(`"4-2"`), &back); err == nil {
+		t.Errorf("Decoded an invalid range")
+	}
+}
+
+func TestUploadState(t *testing.T) {
+	date := time.Date(2015, time.March, 7, 12, 0, 0, 0, time.UTC)
+	segment := func(part int, bytes int64, minutes int) *Segment {
+		return &Segment{Part: part, Bytes: bytes, Date: date.Add(time.Duration(minutes) * time.Minute)}
+	}
+	st := newUploadState("nzb", Upload{Id: "u", Poster: "p"})
+	st.mergeUpload(Upload{Group: []string{"a.b.c"}, Length: 3})
+	st.mergeFile(File{Id: "f1", Filename: "show.part01.rar", Length: 3, Group: []string{"a.b.c"},
+		Segments: []*Segment{segment(1, 100, 0), segment(2, 100, 2)}})
+	st.mergeFile(File{Id: "f2", Filename: "show.part02.rar", Length: 2,
+		Segments: []*Segment{segment(1, 50, 1)}})
+	// Reposted segments are counted once.
+	fs, _ := st.mergeFile(File{Id: "f1", Filename: "show.part01.rar", Length: 3, Group: []string{"a.b.d"},
+		Segments: []*Segment{segment(2, 100, 5), segment(3, 10, 3)}})
+
+	u := st.upload
+	if u.Parts != 5 || u.Complete != 4 || u.Size != 260 || u.Completion != 0.8 || u.Length != 3 {
+		t.Errorf("Upload %+v", u)
+	}
+	if u.FilePrefix != "show.part0" || u.Types["rar"] != 2 || !u.Date.Equal(date.Add(3*time.Minute)) {
+		t.Errorf("Upload prefix %s, types %v, date %s", u.FilePrefix, u.Types, u.Date)
+	}
+	f := st.file(fs)
+	if f.Complete != 3 || f.Completion != 1 || f.Size != 210 || len(f.Group) != 2 || f.ParentId != "u" {
+		t.Errorf("File %+v", f)
+	}
+
+	// The state is kept in the upload document.
+	data, _ := json.Marshal(u)
+	var loaded Upload
+	json.Unmarshal(data, &loaded)
+	st = newUploadState("nzb", loaded)
+	st.mergeFile(File{Id: "f2", Filename: "show.part02.rar", Length: 2,
+		Segments: []*Segment{segment(1, 50, 1), segment(2, 50, 1)}})
+	if st.upload.Complete != 5 || st.upload.Size != 310 || st.upload.Completion != 1 || st.upload.Types["rar"] != 2 {
+		t.Errorf("Loaded upload %+v", st.upload)
+	}
+
+	// Documents written before parts have the parts in length.
+	loaded.Length, loaded.Parts = 5, 0
+	if st = newUploadState("nzb", loaded); st.upload.Parts != 5 || st.upload.Length != 2 {
+		t.Errorf("Upload without parts %+v", st.upload)
+	}
+}
+
+func TestUploadStateSample(t *testing.T) {
+	st := newUploadState("nzb", Upload{Id: "u"})
+	segments := make([]*Segment, 20)
+	for i := range segments {
+		segments[i] = &Segment{Part: i + 1, MessageId: fmt.Sprintf("<%d@test>", i+1)}
+	}
+	st.mergeFile(File{Id: "f1", Length: 20, Segments: segments})
+	st.mergeFile(File{Id: "f2", Length: 2, Segments: segments[:2]})
+	if n := len(st.files["f1"].Sample); n != fileSampleSize {
+		t.Errorf("Sampled %d segments of f1, want %d", n, fileSampleSize)
+	}
+	if n := len(st.files["f2"].Sample); n != 2 {
+		t.Errorf("Sampled %d segments of f2, want 2", n)
+	}
+	sample := st.sample(5)
+	if len(sample) != 5 {
+		t.Fatalf("Sample has %d segments, want 5", len(sample))
+	}
+	for _, s := range sample {
+		found := false
+		for _, id := range s.file.Sample {
+			found = found || id == s.messageId
+		}
+		if !found {
+			t.Errorf("Segment %s isn't in the sample of %s", s.messageId, s.file.Id)
+		}
+	}
+	if n := len(st.sample(100)); n != fileSampleSize+2 {
+		t.Errorf("Sample has %d segments, want %d", n, fileSampleSize+2)
+	}
+}
+
+func TestBufferedProgress(t *testing.T) {
+	// A new upload of 2 files, buffered whole.
+	p := newBufferedProgress(nil, 2)
+	if p.add("f1", 1, 2) || p.add("f1", 2, 2) || p.add("f1", 2, 2) {
+		t.Errorf("Upload complete before its second file")
+	}
+	if !p.add("f2", 1, 1) {
+		t.Errorf("Upload not complete with every segment")
+	}
+	if p.add("f2", 1, 1) {
+		t.Errorf("Upload completed twice")
+	}
+
+	// An upload whose state has a part of its only file.
+	st := newUploadState("nzb", Upload{Id: "u", Length: 1})
+	st.mergeFile(File{Id: "f1", Length: 3, Segments: []*Segment{{Part: 1}}})
+	p = newBufferedProgress(st, 0)
+	if p.add("f1", 1, 3) || p.add("f1", 2, 3) {
+		t.Errorf("Upload complete without part 3")
+	}
+	if !p.add("f1", 3, 3) {
+		t.Errorf("Upload not complete with part 3")
+	}
+
+	// A complete upload doesn't complete again.
+	st.mergeFile(File{Id: "f1", Length: 3, Segments: []*Segment{{Part: 2}, {Part: 3}}})
+	if p = newBufferedProgress(st, 0); p.add("f1", 3, 3) {
+		t.Errorf("Reposted segment completed the upload")
+	}
+}
@@ -0,0 +1,190 @@
+package elasticsink
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/animezb/goes"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var (
+	// stateBucket holds the states by upload id.
+	stateBucket = []byte("uploads")
+	// recentBucket holds the upload ids by the time their state was saved.
+	recentBucket = []byte("recent")
+)
+
+// stateDB keeps the states of the uploads in a bolt database, so the state
+// cache is seeded when the sink starts and uploads evicted from it aren't
+// loaded from the cluster again.
+type stateDB struct {
+	db     *bolt.DB
+	maxAge time.Duration
+}
+
+type storedState struct {
+	Index  string          `json:"index"`
+	Saved  int64           `json:"saved"`
+	Upload json.RawMessage `json:"upload"`
+}
+
+// savedState is an upload document to save in the database.
+type savedState struct {
+	id    string
+	index string
+	doc   []byte
+}
+
+func openStateDB(path string, maxAge time.Duration) (*stateDB, error) {
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(stateBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(recentBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &stateDB{db: db, maxAge: maxAge}, nil
+}
+
+func recentKey(saved int64, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(saved))
+	return append(key, id...)
+}
+
+func decodeState(id string, data []byte) (*uploadState, error) {
+	var stored storedState
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	var u Upload
+	if err := json.Unmarshal(stored.Upload, &u); err != nil {
+		return nil, err
+	}
+	u.Id = id
+	return newUploadState(stored.Index, u), nil
+}
+
+// get returns the state of an upload, or nil if it isn't in the database.
+func (s *stateDB) get(id string) (*uploadState, error) {
+	var st *uploadState
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(stateBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		var err error
+		st, err = decodeState(id, data)
+		return err
+	})
+	return st, err
+}
+
+// put saves upload documents.
+func (s *stateDB) put(states []savedState) error {
+	if len(states) == 0 {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		uploads, recent := tx.Bucket(stateBucket), tx.Bucket(recentBucket)
+		for _, st := range states {
+			var previous storedState
+			if data := uploads.Get([]byte(st.id)); data != nil && json.Unmarshal(data, &previous) == nil {
+				if err := recent.Delete(recentKey(previous.Saved, st.id)); err != nil {
+					return err
+				}
+			}
+			data, err := json.Marshal(storedState{Index: st.index, Saved: now, Upload: st.doc})
+			if err != nil {
+				return err
+			}
+			if err := uploads.Put([]byte(st.id), data); err != nil {
+				return err
+			}
+			if err := recent.Put(recentKey(now, st.id), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// seed removes the states older than the maximum age, and calls add with up
+// to n of the most recent ones, oldest first.
+func (s *stateDB) seed(n int, add func(id string, st *uploadState)) (int, error) {
+	var seeded []string
+	var states []*uploadState
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		uploads, recent := tx.Bucket(stateBucket), tx.Bucket(recentBucket)
+		if s.maxAge > 0 {
+			oldest := recentKey(time.Now().Add(-s.maxAge).UnixNano(), "")
+			var expired [][]byte
+			c := recent.Cursor()
+			for k, _ := c.First(); k != nil && string(k) < string(oldest); k, _ = c.Next() {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			for _, k := range expired {
+				if err := recent.Delete(k); err != nil {
+					return err
+				}
+				if err := uploads.Delete(k[8:]); err != nil {
+					return err
+				}
+			}
+		}
+		c := recent.Cursor()
+		for k, _ := c.Last(); k != nil && len(seeded) < n; k, _ = c.Prev() {
+			id := string(k[8:])
+			st, err := decodeState(id, uploads.Get(k[8:]))
+			if err != nil {
+				continue
+			}
+			seeded = append(seeded, id)
+			states = append(states, st)
+		}
+		return nil
+	})
+	for i := len(seeded) - 1; i >= 0; i-- {
+		add(seeded[i], states[i])
+	}
+	return len(seeded), err
+}
+
+func (s *stateDB) Close() error {
+	return s.db.Close()
+}
+
+// saveStates saves the states of uploads once their documents were sent,
+// but not the ones whose document is dead, the cluster doesn't have what
+// they claim was merged.
+func (es *ElasticSink) saveStates(states []savedState, dead []goes.Document) {
+	if es.stateDB == nil || len(states) == 0 {
+		return
+	}
+	failed := make(map[string]bool)
+	for _, doc := range dead {
+		if doc.Type == "upload" {
+			failed[fmt.Sprint(doc.Id)] = true
+		}
+	}
+	written := make([]savedState, 0, len(states))
+	for _, st := range states {
+		if !failed[st.id] {
+			written = append(written, st)
+		}
+	}
+	if err := es.stateDB.put(written); err != nil {
+		es.logger.Printf("Error: Failed to save the state of %d uploads. (%s)", len(written), err.Error())
+	}
+}
@@ -0,0 +1,91 @@
+package elasticsink
+
+import (
+	"encoding/json"
+	"github.com/animezb/goes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elasticsink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.db")
+	db, err := openStateDB(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	save := func(id string, complete int) {
+		doc, _ := json.Marshal(Upload{Id: id, Complete: complete})
+		if err := db.put([]savedState{{id: id, index: "nzb-2015.03", doc: doc}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("a", 1)
+	save("b", 1)
+	save("c", 1)
+	save("a", 2)
+
+	st, err := db.get("a")
+	if err != nil || st == nil || st.upload.Id != "a" || st.upload.Complete != 2 || st.index != "nzb-2015.03" {
+		t.Errorf("State of a %+v (%v)", st, err)
+	}
+	if st, err := db.get("z"); st != nil || err != nil {
+		t.Errorf("State of z %+v (%v)", st, err)
+	}
+	db.Close()
+
+	db, err = openStateDB(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seeded []string
+	n, err := db.seed(2, func(id string, st *uploadState) {
+		seeded = append(seeded, id)
+	})
+	if err != nil || n != 2 || len(seeded) != 2 || seeded[0] != "c" || seeded[1] != "a" {
+		t.Errorf("Seeded %v (%v), want [c a]", seeded, err)
+	}
+
+	// States older than the maximum age are removed.
+	db.maxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if n, _ := db.seed(10, func(string, *uploadState) {}); n != 0 {
+		t.Errorf("Seeded %d expired states", n)
+	}
+	if st, _ := db.get("b"); st != nil {
+		t.Errorf("Expired state of b is still there")
+	}
+	db.Close()
+}
+
+func TestSaveStates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elasticsink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := openStateDB(filepath.Join(dir, "state.db"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	es := &ElasticSink{stateDB: db, logger: log.New(ioutil.Discard, "", 0)}
+	doc, _ := json.Marshal(Upload{Complete: 1})
+	// The upload document of b was dead lettered, its state isn't saved.
+	es.saveStates([]savedState{{id: "a", doc: doc}, {id: "b", doc: doc}},
+		[]goes.Document{{Type: "file", Id: "a"}, {Type: "upload", Id: "b"}})
+	if st, _ := db.get("a"); st == nil {
+		t.Errorf("State of a isn't saved")
+	}
+	if st, _ := db.get("b"); st != nil {
+		t.Errorf("State of b is saved")
+	}
+}
@@ -0,0 +1,188 @@
+package elasticsink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/animezb/newsrover"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// walSegment is a file of the write ahead log. A segment is removed once it
+// is closed and every article written to it has been flushed.
+type walSegment struct {
+	path    string
+	pending int64
+	closed  int32
+}
+
+// wal is the write ahead log of the articles accepted by the sink. Articles
+// are appended to the current segment before Accept returns, the segment is
+// rotated on every flush, and segments left by a crash are replayed when the
+// sink starts.
+type wal struct {
+	lock    sync.Mutex
+	dir     string
+	sync    bool
+	seq     int64
+	current *walSegment
+	file    *os.File
+	stale   []string
+}
+
+const walSuffix = ".wal"
+
+func openWal(dir string, sync bool) (*wal, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	w := &wal{dir: dir, sync: sync}
+	names, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		w.seq, _ = strconv.ParseInt(strings.TrimSuffix(names[len(names)-1], walSuffix), 10, 64)
+	}
+	w.stale = names
+	return w, nil
+}
+
+// segments returns the names of the segment files in order.
+func (w *wal) segments() ([]string, error) {
+	d, err := os.Open(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	all, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(all))
+	for _, name := range all {
+		if strings.HasSuffix(name, walSuffix) {
+			if _, err := strconv.ParseInt(strings.TrimSuffix(name, walSuffix), 10, 64); err == nil {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (w *wal) create() error {
+	w.seq++
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d%s", w.seq, walSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.current = &walSegment{path: path}
+	return nil
+}
+
+// recover reads the segments left by a previous run and calls replay with
+// every article in them. The articles replay returns true for are counted as
+// pending in their segment, which is removed once they are flushed.
+func (w *wal) recover(replay func(a newsrover.Article, seg *walSegment) bool) error {
+	names := w.stale
+	w.stale = nil
+	for _, name := range names {
+		seg := &walSegment{path: filepath.Join(w.dir, name), pending: 1}
+		f, err := os.Open(seg.path)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var a newsrover.Article
+			if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+				continue
+			}
+			atomic.AddInt64(&seg.pending, 1)
+			if !replay(a, seg) {
+				atomic.AddInt64(&seg.pending, -1)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return err
+		}
+		// The extra pending article kept the segment from being removed
+		// while it was replayed.
+		atomic.StoreInt32(&seg.closed, 1)
+		w.release(seg, 1)
+	}
+	return nil
+}
+
+// append writes articles to the current segment and returns it.
+func (w *wal) append(articles []newsrover.Article) (*walSegment, error) {
+	var buf []byte
+	for _, a := range articles {
+		line, err := json.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.current == nil {
+		if err := w.create(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := w.file.Write(buf); err != nil {
+		return nil, err
+	}
+	if w.sync {
+		if err := w.file.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	atomic.AddInt64(&w.current.pending, int64(len(articles)))
+	return w.current, nil
+}
+
+// rotate closes the current segment, the next articles are written to a new
+// one.
+func (w *wal) rotate() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closeCurrent()
+}
+
+func (w *wal) closeCurrent() {
+	if w.current == nil {
+		return
+	}
+	w.file.Close()
+	seg := w.current
+	w.current, w.file = nil, nil
+	atomic.StoreInt32(&seg.closed, 1)
+	w.release(seg, 0)
+}
+
+// release marks n articles of a segment as flushed.
+func (w *wal) release(seg *walSegment, n int64) {
+	if atomic.AddInt64(&seg.pending, -n) == 0 && atomic.LoadInt32(&seg.closed) == 1 {
+		os.Remove(seg.path)
+	}
+}
+
+func (w *wal) Close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closeCurrent()
+}
@@ -0,0 +1,56 @@
+package elasticsink
+
+import (
+	"github.com/animezb/newsrover"
+	"os"
+	"testing"
+)
+
+func TestWal(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWal(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flushed, err := w.append([]newsrover.Article{{MessageId: "<1@test>"}, {MessageId: "<2@test>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.rotate()
+	lost, err := w.append([]newsrover.Article{{MessageId: "<3@test>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.release(flushed, 2)
+	if _, err := os.Stat(flushed.path); !os.IsNotExist(err) {
+		t.Errorf("Flushed segment %s was not removed", flushed.path)
+	}
+
+	// Recover the article that was not flushed, as if the sink was killed.
+	w, err = openWal(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recovered []*walSegment
+	err = w.recover(func(a newsrover.Article, seg *walSegment) bool {
+		if a.MessageId != "<3@test>" {
+			t.Errorf("Recovered %s", a.MessageId)
+		}
+		recovered = append(recovered, seg)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 1 {
+		t.Fatalf("Recovered %d articles, want 1", len(recovered))
+	}
+	if next, _ := w.append([]newsrover.Article{{MessageId: "<4@test>"}}); next.path <= lost.path {
+		t.Errorf("New segment %s sorts before %s", next.path, lost.path)
+	}
+	w.release(recovered[0], 1)
+	if _, err := os.Stat(lost.path); !os.IsNotExist(err) {
+		t.Errorf("Recovered segment %s was not removed once flushed", lost.path)
+	}
+	w.Close()
+}